@@ -1,275 +1,1407 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// APIServer struct holds the server's listening address and the storage interface
+// APIServer struct holds the server's listening address, the storage interface and the configuration
 type APIServer struct {
-	listenAddr string
-	store      Storage
+	listenAddr      string
+	store           Storage
+	config          *Config
+	pinLockout      *lockout                                   // Tracks wrong transaction PINs per account
+	email           EmailSender                                // Delivers the emails sent to account holders
+	health          atomic.Value                               // Latest health state, one of the Health constants
+	now             func() time.Time                           // Clock, replaceable in tests
+	logins          flightGroup                                // Deduplicates concurrent identical logins
+	comparePassword func(acc *Account, password string) bool   // Password check, replaceable in tests
+	loginPenalty    *penalty                                   // Slows down repeated failed logins per client and account
+	loginLockout    *lockout                                   // Tracks failed logins per account number, whatever the client
+	sleep           func(ctx context.Context, d time.Duration) // Waits out login penalties, replaceable in tests
+	metrics         *metrics                                   // Prometheus metrics served on /metrics
+	webhooks        *webhookDispatcher                         // Notifies account webhooks of balance changes
 }
 
-// NewAPIServer creates and returns a new APIServer instance with the given address and storage
-func NewAPIServer(listenAddr string, store Storage) *APIServer {
-	return &APIServer{
-		listenAddr: listenAddr,
-		store:      store,
+// NewAPIServer creates and returns a new APIServer instance with the given address, storage and configuration
+func NewAPIServer(listenAddr string, store Storage, config *Config) *APIServer {
+	s := &APIServer{
+		listenAddr:      listenAddr,
+		store:           store,
+		config:          config,
+		pinLockout:      newLockout(config.PinMaxAttempts, config.PinLockout),
+		email:           logEmailSender{},
+		now:             time.Now,
+		comparePassword: (*Account).ValidPassword,
+		loginPenalty:    newPenalty(config.LoginPenaltyBase, config.LoginPenaltyMax, config.LoginPenaltyWindow),
+		loginLockout:    newLockout(config.LoginMaxAttempts, config.LoginLockout),
+		sleep:           sleepContext,
+		metrics:         newMetrics(store),
+		webhooks:        newWebhookDispatcher(config.WebhookAttempts, config.WebhookBackoff, config.WebhookAllowPrivate),
 	}
+	s.loginPenalty.now = func() time.Time { return s.now() }
+	s.loginLockout.now = func() time.Time { return s.now() }
+	s.health.Store(HealthOK)
+	return s
 }
 
-// Run starts the HTTP server with all defined routes
-func (s *APIServer) Run() {
+// Run starts the HTTP server with all defined routes and serves until ctx is done, e.g. on SIGINT or SIGTERM
+func (s *APIServer) Run(ctx context.Context) error {
+	// Activation links would never reach the account holders
+	if s.config.RequireActivation && !deliversEmail(s.email) {
+		return errors.New("REQUIRE_ACTIVATION needs an email sender that delivers the activation links")
+	}
+
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	// Log the server start message
+	log.Println("JSON API server running on port: ", s.listenAddr)
+	if s.config.TLSCertFile == "" {
+		log.Println("warning: TLS is not configured, tokens and passwords are sent in the clear")
+	}
+	return s.serve(ctx, listener)
+}
+
+// serve serves requests on the listener until ctx is done, then lets in-flight requests finish within
+// the configured shutdown timeout
+func (s *APIServer) serve(ctx context.Context, listener net.Listener) error {
+	// Keep track of the database health in the background
+	go s.monitorHealth(ctx, s.config.HealthCheckInterval)
+
+	// Cancel transfers left unapproved past their expiry
+	if s.config.ApprovalThreshold > 0 || s.config.AnomalyHold {
+		go s.cancelExpiredTransfers(ctx, time.Minute)
+	}
+
+	// Start the HTTP server, logging and measuring every request including the unrouted ones
+	server := &http.Server{Handler: loggingMiddleware(s.metrics.middleware(s.router()))}
+	serveErr := make(chan error, 1)
+	go func() {
+		// Terminate TLS in the server when a certificate is configured
+		if s.config.TLSCertFile != "" {
+			serveErr <- server.ServeTLS(listener, s.config.TLSCertFile, s.config.TLSKeyFile)
+			return
+		}
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and wait for the in-flight requests
+	log.Println("shutting down, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// router creates the router with all routes, their handlers and middlewares
+func (s *APIServer) router() *mux.Router {
 	// Create a new router
 	router := mux.NewRouter()
 
-	// Define routes and their handlers
-	router.HandleFunc("/login", makeHTTPHandleFunc(s.handleLogin))
-	router.HandleFunc("/account", makeHTTPHandleFunc(s.handleAccount))
-	router.HandleFunc("/account/{id}", withJWTAuth(makeHTTPHandleFunc(s.handleGetAccountByID), s.store))
-	router.HandleFunc("/transfer", makeHTTPHandleFunc(s.handleTransfer))
+	// Define routes, their methods and handlers; other methods are rejected by the router
+	router.HandleFunc("/health", makeHTTPHandleFunc(s.handleHealth)).Methods("GET")
+	router.Handle("/metrics", s.metrics.handler()).Methods("GET")
+	router.HandleFunc("/login", makeHTTPHandleFunc(s.handleLogin)).Methods("POST")
+	router.HandleFunc("/refresh", withAuth(makeHTTPHandleFunc(s.handleRefresh), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/logout", makeHTTPHandleFunc(s.handleLogout)).Methods("POST")
+	router.HandleFunc("/account", makeHTTPHandleFunc(s.handleAccount)).Methods("GET", "POST")
+	router.HandleFunc("/me", withAuth(makeHTTPHandleFunc(s.handleGetMe), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/activate", makeHTTPHandleFunc(s.handleActivate)).Methods("GET")
+	router.HandleFunc("/password-reset/request", makeHTTPHandleFunc(s.handleRequestPasswordReset)).Methods("POST")
+	router.HandleFunc("/password-reset/confirm", makeHTTPHandleFunc(s.handleConfirmPasswordReset)).Methods("POST")
+	router.HandleFunc("/accounts", makeHTTPHandleFunc(s.handleGetAccountsByIDs)).Methods("GET")
+	router.HandleFunc("/account/search", makeHTTPHandleFunc(s.handleSearchAccounts)).Methods("GET")
+	router.HandleFunc("/account/by-number/{number}", makeHTTPHandleFunc(s.handleGetAccountByNumber)).Methods("GET")
+	router.HandleFunc("/account/{id}", withJWTAuth(makeHTTPHandleFunc(s.handleGetAccountByID), s.store, s.config)).Methods("GET", "PUT", "DELETE")
+	router.HandleFunc("/account/{id}/balance", withJWTAuth(makeHTTPHandleFunc(s.handleGetBalance), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/account/{id}/inbound", withJWTAuth(makeHTTPHandleFunc(s.handleSetAcceptsInbound), s.store, s.config)).Methods("PUT")
+	router.HandleFunc("/account/{id}/webhook", withJWTAuth(makeHTTPHandleFunc(s.handleSetWebhook), s.store, s.config)).Methods("PUT")
+	router.HandleFunc("/account/{id}/transactions", withJWTAuth(makeHTTPHandleFunc(s.handleGetTransactions), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/account/{id}/statement", withJWTAuth(makeHTTPHandleFunc(s.handleGetStatement), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/account/{id}/verify-ledger", withJWTAuth(makeHTTPHandleFunc(s.handleVerifyLedger), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/account/{id}/deposit", withJWTAuth(makeHTTPHandleFunc(s.handleDeposit), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/account/{id}/withdraw", withJWTAuth(makeHTTPHandleFunc(s.handleWithdraw), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/account/{id}/export", withJWTAuth(makeHTTPHandleFunc(s.handleExportAccount), s.store, s.config)).Methods("GET")
+	router.HandleFunc("/account/{id}/freeze", makeHTTPHandleFunc(s.handleSetAccountStatus(StatusFrozen, AuditAccountFreeze))).Methods("PATCH")
+	router.HandleFunc("/account/{id}/unfreeze", makeHTTPHandleFunc(s.handleSetAccountStatus(StatusActive, AuditAccountUnfreeze))).Methods("PATCH")
+	router.HandleFunc("/account/{id}/pin", withJWTAuth(makeHTTPHandleFunc(s.handleSetPin), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/account/{id}/password", withJWTAuth(makeHTTPHandleFunc(s.handleChangePassword), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/transfer", withAuth(makeHTTPHandleFunc(s.handleTransfer), s.store, s.config)).Methods("POST")
+	router.HandleFunc("/transfer/{id}/approve", makeHTTPHandleFunc(s.handleApproveTransfer)).Methods("POST")
+
+	// Admin-only routes live under /admin, restricted to the configured networks
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(ipFilterMiddleware(s.config.AdminAllowCIDRs, s.config.AdminDenyCIDRs, s.config.TrustedProxies))
+	admin.HandleFunc("/account/{id}/rotate-number", makeHTTPHandleFunc(s.handleRotateNumber)).Methods("POST")
+
+	// Allow browser clients, answering preflight requests before any other middleware
+	router.Use(corsMiddleware(s.config.CORSAllowedOrigin))
+
+	// Preflight requests match no route, so the 405 handler answers them too
+	router.MethodNotAllowedHandler = corsMiddleware(s.config.CORSAllowedOrigin)(allowedMethodsHandler(router))
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, http.StatusNotFound, ApiError{Message: "not found"})
+	})
+
+	// Report the handling time to clients
+	if s.config.ResponseTiming {
+		router.Use(timingMiddleware)
+	}
+
+	// Assign every request an ID, which a panic is logged with, and mark deprecated endpoints and fields
+	router.Use(requestIDMiddleware(s.config.RequireRequestID))
+	router.Use(recoverMiddleware)
+	router.Use(deprecationMiddleware(s.config.Deprecations))
+
+	// Cap request bodies before any handler reads them
+	if s.config.MaxBodyBytes > 0 {
+		router.Use(bodyLimitMiddleware(s.config.MaxBodyBytes))
+	}
+
+	// Keep serving reads when the database stops accepting writes
+	if s.config.DegradedMode {
+		router.Use(s.degradedMiddleware)
+	}
+
+	return router
+}
+
+// handleLogin handles the login request, verifies the credentials, and returns a JWT token
+func (s *APIServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
+	// Decode the login request body
+	var req LoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	// Accounts log in by exactly one of number and email; reject malformed numbers before querying the storage
+	switch {
+	case req.Number != 0 && req.Email != "":
+		return validationErrorf("log in with either number or email, not both")
+	case req.Email != "":
+	case req.Number != 0:
+		if err := s.config.AccountNumbers.Validate(req.Number); err != nil {
+			return err
+		}
+	default:
+		return validationErrorf("number or email is required")
+	}
+
+	// Refuse clients still serving the penalty of their last failed login
+	penaltyKey := clientIP(r, s.config.TrustedProxies).String() + ":" + req.login()
+	if s.config.LoginPenaltyMode == PenaltyReject {
+		if wait := s.loginPenalty.Remaining(penaltyKey); wait > 0 {
+			return &ErrTooManyRequests{Code: "login_penalty", Message: "too many failed logins, please wait before retrying", RetryAfter: wait}
+		}
+	}
+
+	// Refuse any client while the account number is locked out, so brute force can't be spread over many IPs
+	lockoutKey := req.login()
+	if s.config.LoginMaxAttempts > 0 {
+		if locked, wait := s.loginLockout.Locked(lockoutKey); locked {
+			return &ErrTooManyRequests{Code: "login_locked", Message: "too many attempts, try again later", RetryAfter: wait}
+		}
+	}
+
+	// Retrieve the account and verify the password
+	acc, err := s.authenticate(r.Context(), req)
+	if err != nil {
+		if s.config.LoginMaxAttempts > 0 {
+			s.loginLockout.Fail(lockoutKey)
+		}
+
+		// Each consecutive failure costs twice as much as the previous one
+		delay := s.loginPenalty.Fail(penaltyKey)
+		if s.config.LoginPenaltyMode == PenaltyDelay {
+			s.sleep(r.Context(), delay)
+		}
+		return err
+	}
+	s.loginPenalty.Reset(penaltyKey)
+	s.loginLockout.Reset(lockoutKey)
+
+	// Create a JWT token for the authenticated account
+	token, err := createJWT(acc, s.config.JWTSecret, s.config.TokenTTL, s.config.TokenMaxTTL)
+	if err != nil {
+		return err
+	}
+
+	// Send the token and account number as the response
+	resp := LoginResponse{
+		Token:  token,
+		Number: acc.Number,
+	}
+
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// handleRefresh exchanges a still-valid token for a fresh one, without asking for the password again
+// Expired and malformed tokens, and tokens of accounts that no longer exist, are turned away by withAuth
+func (s *APIServer) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	acc := accountFromContext(r)
+	token, err := createJWT(acc, s.config.JWTSecret, s.config.TokenTTL, s.config.TokenMaxTTL)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, LoginResponse{Token: token, Number: acc.Number})
+}
+
+// handleLogout revokes the token sent with the request, so it can't be used again even though it hasn't expired
+func (s *APIServer) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	token, err := validateJWT(r.Context(), s.store, r.Header.Get("x-jwt-token"), s.config.JWTSecret, s.config.JWTLeeway)
+	if err != nil {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	// The revocation is only needed until the token expires on its own
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return validationErrorf("invalid token claims")
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return validationErrorf("token has no ID and can't be revoked")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return validationErrorf("invalid token claims")
+	}
+	if err := s.store.RevokeToken(r.Context(), jti, time.Unix(int64(exp), 0).UTC()); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"loggedOut": true})
+}
+
+// login returns the name the request logs in with, keying its failed attempts
+func (req LoginRequest) login() string {
+	if req.Email != "" {
+		return "email:" + req.Email
+	}
+	return strconv.FormatInt(req.Number, 10)
+}
+
+// authenticate looks up the account of the login request and verifies its password
+// Concurrent identical logins, e.g. from a retrying client, share a single bcrypt comparison
+func (s *APIServer) authenticate(ctx context.Context, req LoginRequest) (*Account, error) {
+	login := func() (any, error) {
+		var acc *Account
+		var err error
+		if req.Email != "" {
+			acc, err = s.store.GetAccountByEmail(ctx, req.Email)
+		} else {
+			acc, err = s.store.GetAccountByNumber(ctx, int(req.Number))
+		}
+		// An unknown login fails like a wrong password, so logins can't probe which accounts exist
+		if errors.Is(err, errAccountNotFound) {
+			return nil, validationErrorf("not authenticated")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.comparePassword(acc, req.Password) {
+			return nil, validationErrorf("not authenticated")
+		}
+		return acc, nil
+	}
+
+	if !s.config.LoginDedup {
+		acc, err := login()
+		if err != nil {
+			return nil, err
+		}
+		return acc.(*Account), nil
+	}
+
+	// Key by a hash of the password so the plain text isn't kept around
+	sum := sha256.Sum256([]byte(req.Password))
+	key := req.login() + ":" + hex.EncodeToString(sum[:])
+	acc, err, shared := s.logins.Do(key, login)
+	if shared {
+		s.metrics.sharedLogins.Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers share the account, so each gets its own copy
+	copied := *acc.(*Account)
+	return &copied, nil
+}
+
+// handleAccount handles both GET and POST requests for accounts
+func (s *APIServer) handleAccount(w http.ResponseWriter, r *http.Request) error {
+	// Handle GET and POST requests
+	if r.Method == "GET" {
+		return s.handleGetAccount(w, r)
+	}
+	if r.Method == "POST" {
+		return s.handleCreateAccount(w, r)
+	}
+
+	// Reject any other method
+	return methodNotAllowed(w, "GET", "POST")
+}
+
+// handleGetAccount retrieves a page of the accounts and sends it as a response, e.g. GET /account?limit=25&offset=50
+// Given a cursor instead of an offset, e.g. ?cursor= for the first page, the page carries the cursor of the next one
+// Only admins may list the accounts of every holder, including the deleted ones with includeDeleted=true
+func (s *APIServer) handleGetAccount(w http.ResponseWriter, r *http.Request) error {
+	admin, err := authenticatedAccount(r, s.store, s.config)
+	if err != nil || !admin.IsAdmin() {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+	if limit == 0 {
+		limit = defaultAccountPageSize
+	}
+
+	cursor, byCursor, err := parseCursor(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := parseAccountFilter(r)
+	if err != nil {
+		return err
+	}
+
+	// Retrieve the page and the total for the page controls of clients
+	page := AccountPage{Limit: limit, Offset: offset}
+	if byCursor {
+		// Fetch one account more than the page to learn whether another page follows
+		page.Accounts, err = s.store.GetAccountsAfter(r.Context(), cursor, limit+1, filter)
+		if len(page.Accounts) > limit {
+			page.Accounts = page.Accounts[:limit]
+			page.NextCursor = encodeCursor(page.Accounts[limit-1].ID)
+		}
+	} else {
+		page.Accounts, err = s.store.GetAccountsPaginated(r.Context(), limit, offset, filter)
+	}
+	if err != nil {
+		return err
+	}
+	page.Total, err = s.store.CountAccounts(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	// Send the accounts as JSON response
+	s.presentAccounts(page.Accounts...)
+	return WriteJSON(w, http.StatusOK, page)
+}
+
+// handleSearchAccounts lets an admin find accounts by holder name, e.g. GET /account/search?q=smith
+func (s *APIServer) handleSearchAccounts(w http.ResponseWriter, r *http.Request) error {
+	admin, err := authenticatedAccount(r, s.store, s.config)
+	if err != nil || !admin.IsAdmin() {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	// An empty query would match every account
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return validationErrorf("search query q is required")
+	}
+
+	accounts, err := s.store.SearchAccounts(r.Context(), query)
+	if err != nil {
+		return err
+	}
+
+	s.presentAccounts(accounts...)
+	return WriteJSON(w, http.StatusOK, accounts)
+}
+
+// handleGetAccountsByIDs retrieves several accounts in one call, e.g. GET /accounts?ids=1,2,3
+// Admins receive every requested account; other callers only their own
+func (s *APIServer) handleGetAccountsByIDs(w http.ResponseWriter, r *http.Request) error {
+	// Resolve the caller from the JWT token
+	caller, err := authenticatedAccount(r, s.store, s.config)
+	if err != nil {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	// Parse the comma separated list of ids
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		return err
+	}
+	if len(ids) > s.config.BatchGetMaxIDs {
+		return validationErrorf("too many ids given, maximum is %d", s.config.BatchGetMaxIDs)
+	}
+
+	// Retrieve the requested accounts; missing ids are simply omitted
+	accounts, err := s.store.GetAccountsByIDs(r.Context(), ids)
+	if err != nil {
+		return err
+	}
+
+	// Admins get the full detail of every account
+	s.presentAccounts(accounts...)
+	if caller.IsAdmin() {
+		return WriteJSON(w, http.StatusOK, accounts)
+	}
+
+	// Non-admins only get to see the summary of their own account
+	owned := []*AccountSummary{}
+	for _, acc := range accounts {
+		if acc.ID == caller.ID {
+			owned = append(owned, acc.Summary())
+		}
+	}
+	return WriteJSON(w, http.StatusOK, owned)
+}
+
+// handleGetAccountByID retrieves an account by ID or deletes it if DELETE method is used
+func (s *APIServer) handleGetAccountByID(w http.ResponseWriter, r *http.Request) error {
+	// Handle GET method for fetching an account by ID
+	if r.Method == "GET" {
+		// withJWTAuth already loaded the account
+		account := accountFromContext(r)
+		s.presentAccounts(account)
+		return WriteJSON(w, http.StatusOK, account)
+	}
+
+	// Handle PUT method for updating the account details
+	if r.Method == "PUT" {
+		return s.handleUpdateAccount(w, r)
+	}
+
+	// Handle DELETE method for deleting an account
+	if r.Method == "DELETE" {
+		return s.handleDeleteAccount(w, r)
+	}
+
+	// Reject any other method
+	return methodNotAllowed(w, "GET", "PUT", "DELETE")
+}
+
+// handleGetMe returns the account the JWT token was issued for, so clients don't need to know its ID
+func (s *APIServer) handleGetMe(w http.ResponseWriter, r *http.Request) error {
+	account := accountFromContext(r)
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// handleGetBalance returns only the balance of an account, e.g. GET /account/1/balance
+// Polling clients get a small payload instead of the full account
+func (s *APIServer) handleGetBalance(w http.ResponseWriter, r *http.Request) error {
+	// withJWTAuth already loaded the account
+	account := accountFromContext(r)
+	return WriteJSON(w, http.StatusOK, &BalanceResponse{
+		Number:   account.Number,
+		Balance:  account.Balance,
+		Currency: s.currencyOf(account),
+	})
+}
+
+// handleGetAccountByNumber retrieves the account of the caller by its account number, e.g. GET /account/by-number/100001
+// Account holders know their number rather than the internal ID; only the number of the token is accepted
+func (s *APIServer) handleGetAccountByNumber(w http.ResponseWriter, r *http.Request) error {
+	// The number must be the one the token was issued for
+	token, err := validateJWT(r.Context(), s.store, r.Header.Get("x-jwt-token"), s.config.JWTSecret, s.config.JWTLeeway)
+	if err != nil || !token.Valid {
+		permissionDenied(w, err)
+		return nil
+	}
+	numberStr := mux.Vars(r)["number"]
+	number, err := strconv.ParseInt(numberStr, 10, 64)
+	if err != nil {
+		return validationErrorf("invalid account number given %s", numberStr)
+	}
+	if err := s.config.AccountNumbers.Validate(number); err != nil {
+		return err
+	}
+	tokenNumber, err := tokenAccountNumber(token)
+	if err != nil || tokenNumber != number {
+		permissionDenied(w, nil)
+		return nil
+	}
+
+	// The account may have been deleted or renumbered since the token was issued
+	account, err := s.store.GetAccountByNumber(r.Context(), int(number))
+	if errors.Is(err, errAccountNotFound) {
+		return WriteError(w, http.StatusNotFound, ApiError{Message: err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// handleCreateAccount creates a new account and stores it
+func (s *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request) error {
+	// Decode the request body to create an account
+	req := new(CreateAccountRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+
+	// Look up the rules of the requested account type
+	accType, err := s.config.AccountType(req.Type)
+	if err != nil {
+		return err
+	}
+	currencyCode, err := parseCurrency(req.Currency, s.config.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	// Create a new account
+	account, err := NewAccount(req.FirstName, req.LastName, req.Password, accType)
+	if err != nil {
+		return err
+	}
+	account.Currency = currencyCode
+
+	// Accounts only need activating when the feature is enabled
+	account.Email = req.Email
+	account.Activated = !s.config.RequireActivation
+	if s.config.RequireActivation && req.Email == "" {
+		return validationErrorf("email is required")
+	}
+	if req.Email != "" {
+		if err := validateEmail(req.Email); err != nil {
+			return err
+		}
+	}
+
+	token, err := s.storeNewAccount(r.Context(), account)
+	var conflict *ErrConflict
+	if errors.As(err, &conflict) && conflict.Constraint == accountEmailKey {
+		return &ErrConflict{Constraint: accountEmailKey, Message: "email address is already in use"}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Email the activation link; the account exists either way, so a delivery failure is only logged
+	if token != "" {
+		body := fmt.Sprintf("Activate your account %s: /activate?token=%s", maskNumber(account.Number), token)
+		if err := s.email.Send(account.Email, "Activate your account", body); err != nil {
+			logRequestf(r, "sending activation email for account %d: %v", account.ID, err)
+		}
+	}
+
+	// Send the created account as JSON response
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// storeNewAccount assigns the account an unused number and stores it together with its activation token,
+// returning the token; a number taken by a concurrent signup in the meantime is replaced by another one
+func (s *APIServer) storeNewAccount(ctx context.Context, account *Account) (string, error) {
+	for attempt := 1; ; attempt++ {
+		number, err := s.config.AccountNumbers.Assign(ctx, s.store)
+		if err != nil {
+			return "", err
+		}
+		account.Number = number
+
+		var token string
+		err = s.store.WithTx(ctx, func(tx Storage) error {
+			if err := tx.CreateAccount(ctx, account); err != nil {
+				return err
+			}
+			if account.Activated {
+				return nil
+			}
+
+			t, accountToken, err := newAccountToken(account.ID, TokenActivation, s.config.ActivationTokenTTL, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			token = t
+			return tx.CreateAccountToken(ctx, accountToken)
+		})
+
+		// Any other conflict, such as a taken email address, is the client's to resolve
+		var conflict *ErrConflict
+		if errors.As(err, &conflict) && conflict.Constraint == accountNumberKey && attempt < maxCreateAttempts {
+			continue
+		}
+		return token, err
+	}
+}
+
+// handleActivate activates the account of the emailed activation token
+func (s *APIServer) handleActivate(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return errInvalidToken
+	}
+
+	// Use up the token and activate its account
+	var account *Account
+	err := s.store.WithTx(r.Context(), func(tx Storage) error {
+		id, err := tx.ConsumeAccountToken(r.Context(), TokenActivation, hashToken(token), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := tx.SetActivated(r.Context(), id); err != nil {
+			return err
+		}
+		account, err = tx.GetAccountByID(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// handleRequestPasswordReset emails a password reset token to the account with the given email address
+// The response is the same whether or not such an account exists
+func (s *APIServer) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	req := new(PasswordResetRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+
+	// Without delivery the token could only end up in the logs
+	if !deliversEmail(s.email) {
+		return errEmailUnavailable
+	}
+
+	response := map[string]string{"status": "if an account uses this email, a reset link has been sent"}
+	account, err := s.store.GetAccountByEmail(r.Context(), req.Email)
+	if err != nil {
+		return WriteJSON(w, http.StatusAccepted, response)
+	}
+
+	// Store the hashed token and email the token itself
+	token, accountToken, err := newAccountToken(account.ID, TokenPasswordReset, s.config.PasswordResetTTL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateAccountToken(r.Context(), accountToken); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Reset the password of your account %s with this token: %s", maskNumber(account.Number), token)
+	if err := s.email.Send(account.Email, "Reset your password", body); err != nil {
+		logRequestf(r, "sending password reset email for account %d: %v", account.ID, err)
+	}
+
+	return WriteJSON(w, http.StatusAccepted, response)
+}
+
+// handleConfirmPasswordReset sets a new password for the account of a password reset token
+func (s *APIServer) handleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	req := new(PasswordResetConfirmRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := validatePassword(req.Password); err != nil {
+		return err
+	}
+
+	encpw, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// Use up the token and store the new password
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		id, err := tx.ConsumeAccountToken(r.Context(), TokenPasswordReset, hashToken(req.Token), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		return tx.UpdatePassword(r.Context(), id, string(encpw))
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"passwordReset": true})
+}
+
+// handleDeleteAccount soft deletes an account by its ID, keeping its history for the audit trail
+func (s *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
+	// Get the account ID from the URL
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	// Delete the account from the storage
+	if err := s.store.DeleteAccount(r.Context(), id); err != nil {
+		return err
+	}
+
+	// Send a confirmation response
+	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
+}
+
+// handleUpdateAccount lets the account owner change the name on the account, e.g.
+// PUT /account/1 {"lastName":"Smith"}
+func (s *APIServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	// Decode the request body
+	req := new(UpdateAccountRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if req.FirstName == nil && req.LastName == nil {
+		return validationErrorf("firstName or lastName is required")
+	}
+
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	// Names that are sent must not be blank
+	if req.FirstName != nil {
+		if account.FirstName = strings.TrimSpace(*req.FirstName); account.FirstName == "" {
+			return validationErrorf("firstName must not be blank")
+		}
+	}
+	if req.LastName != nil {
+		if account.LastName = strings.TrimSpace(*req.LastName); account.LastName == "" {
+			return validationErrorf("lastName must not be blank")
+		}
+	}
+
+	// Store the new name and send the updated account as response
+	if err := s.store.UpdateAccount(r.Context(), id, account.FirstName, account.LastName); err != nil {
+		return err
+	}
+
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// handleSetAcceptsInbound lets the account owner opt in or out of incoming transfers
+func (s *APIServer) handleSetAcceptsInbound(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	// Decode the request body
+	req := new(AcceptsInboundRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
 
-	// Log the server start message
-	log.Println("JSON API server running on port: ", s.listenAddr)
+	// Store the new setting and send the updated account as response
+	if err := s.store.SetAcceptsInbound(r.Context(), id, req.AcceptsInbound); err != nil {
+		return err
+	}
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
 
-	// Start the HTTP server
-	http.ListenAndServe(s.listenAddr, router)
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
 }
 
-// handleLogin handles the login request, verifies the credentials, and returns a JWT token
-func (s *APIServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
-	// Only allow POST method
-	if r.Method != "POST" {
-		return fmt.Errorf("method not allowed %s", r.Method)
+// handleGetTransactions sends the transaction history of an account, newest first, e.g.
+// GET /account/1/transactions?limit=20&offset=40 for the third page of 20
+// Given a cursor, e.g. ?cursor= for the first page, it sends a TransactionPage with the cursor of the next one
+// Private notes are only included on the transactions the account sent
+func (s *APIServer) handleGetTransactions(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
 	}
 
-	// Decode the login request body
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	limit, offset, err := parsePage(r)
+	if err != nil {
 		return err
 	}
-
-	// Retrieve the account by account number
-	acc, err := s.store.GetAccountByNumber(int(req.Number))
+	cursor, byCursor, err := parseCursor(r)
 	if err != nil {
 		return err
 	}
 
-	// Verify the provided password
-	if !acc.ValidPassword(req.Password) {
-		return fmt.Errorf("not authenticated")
+	var transactions []*Transaction
+	var nextCursor string
+	if byCursor {
+		if limit == 0 {
+			limit = defaultTransactionPageSize
+		}
+		// Fetch one transaction more than the page to learn whether another page follows
+		transactions, err = s.store.GetTransactionsBefore(r.Context(), id, cursor, limit+1)
+		if len(transactions) > limit {
+			transactions = transactions[:limit]
+			nextCursor = encodeCursor(transactions[limit-1].ID)
+		}
+	} else {
+		transactions, err = s.store.GetTransactionsByAccount(r.Context(), id, limit, offset)
 	}
-
-	// Create a JWT token for the authenticated account
-	token, err := createJWT(acc)
 	if err != nil {
 		return err
 	}
+	hidePrivateNotes(id, transactions)
+	s.presentTransactions(accountFromContext(r), transactions)
 
-	// Send the token and account number as the response
-	resp := LoginResponse{
-		Token:  token,
-		Number: acc.Number,
+	if byCursor {
+		return WriteJSON(w, http.StatusOK, TransactionPage{Transactions: transactions, NextCursor: nextCursor})
 	}
+	return WriteJSON(w, http.StatusOK, transactions)
+}
 
-	return WriteJSON(w, http.StatusOK, resp)
+// hidePrivateNotes clears the notes of the transactions the account didn't send, which only the sender may see
+func hidePrivateNotes(accountID int, transactions []*Transaction) {
+	for _, t := range transactions {
+		if t.FromAccount != accountID {
+			t.PrivateNote = ""
+		}
+	}
 }
 
-// handleAccount handles both GET and POST requests for accounts
-func (s *APIServer) handleAccount(w http.ResponseWriter, r *http.Request) error {
-	// Handle GET and POST requests
-	if r.Method == "GET" {
-		return s.handleGetAccount(w, r)
+// handleDeposit credits the account balance and sends the updated account as the response
+func (s *APIServer) handleDeposit(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
 	}
-	if r.Method == "POST" {
-		return s.handleCreateAccount(w, r)
+
+	// Decode the request body
+	req := new(DepositRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if req.Amount <= 0 {
+		return validationErrorf("deposit amount must be positive")
+	}
+
+	// Credit the balance and record the deposit
+	var account *Account
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		if err := tx.UpdateBalance(r.Context(), id, req.Amount); err != nil {
+			return err
+		}
+		deposit := &Transaction{ToAccount: id, Amount: req.Amount, Kind: TransactionDeposit}
+		if err := s.recordTransaction(r.Context(), tx, deposit); err != nil {
+			return err
+		}
+		account, err = tx.GetAccountByID(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	s.webhooks.Notify(balanceChange{Account: account, Kind: TransactionDeposit, Amount: req.Amount})
 
-	// Return an error if the method is not allowed
-	return fmt.Errorf("method not allowed %s", r.Method)
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
 }
 
-// handleGetAccount retrieves all accounts and sends them as a response
-func (s *APIServer) handleGetAccount(w http.ResponseWriter, r *http.Request) error {
-	// Retrieve all accounts from the storage
-	accounts, err := s.store.GetAccounts()
+// handleWithdraw debits the account balance and sends the updated account as the response
+func (s *APIServer) handleWithdraw(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
 	if err != nil {
 		return err
 	}
 
-	// Send the accounts as JSON response
-	return WriteJSON(w, http.StatusOK, accounts)
-}
+	// Decode the request body
+	req := new(WithdrawalRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if req.Amount <= 0 {
+		return validationErrorf("withdrawal amount must be positive")
+	}
 
-// handleGetAccountByID retrieves an account by ID or deletes it if DELETE method is used
-func (s *APIServer) handleGetAccountByID(w http.ResponseWriter, r *http.Request) error {
-	// Handle GET method for fetching an account by ID
-	if r.Method == "GET" {
-		id, err := getID(r)
+	// Debit the balance under the row lock and record the withdrawal
+	var account *Account
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		accounts, err := tx.LockAccounts(r.Context(), []int{id})
 		if err != nil {
 			return err
 		}
+		if len(accounts) == 0 {
+			return fmt.Errorf("account %d %w", id, errAccountNotFound)
+		}
+		account = accounts[0]
+		if err := checkAccountStatus(account); err != nil {
+			return err
+		}
 
-		account, err := s.store.GetAccountByID(id)
-		if err != nil {
+		// The account may not drop below the minimum balance of its type
+		if account.Balance-req.Amount < account.MinBalance {
+			return &TransferError{Code: "insufficient_funds", Message: "insufficient funds"}
+		}
+		if err := tx.UpdateBalance(r.Context(), id, -req.Amount); err != nil {
 			return err
 		}
+		account.Balance -= req.Amount
 
-		return WriteJSON(w, http.StatusOK, account)
+		withdrawal := &Transaction{FromAccount: id, Amount: req.Amount, Kind: TransactionWithdrawal}
+		return s.recordTransaction(r.Context(), tx, withdrawal)
+	})
+	if err != nil {
+		return err
 	}
+	s.webhooks.Notify(balanceChange{Account: account, Kind: TransactionWithdrawal, Amount: -req.Amount})
 
-	// Handle DELETE method for deleting an account
-	if r.Method == "DELETE" {
-		return s.handleDeleteAccount(w, r)
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
+
+// handleSetPin enrolls or replaces the transaction PIN of an account, confirmed with the account password
+func (s *APIServer) handleSetPin(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
 	}
 
-	// Return an error if the method is not allowed
-	return fmt.Errorf("method not allowed %s", r.Method)
-}
+	// Decode the request body
+	req := new(SetPinRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := validatePin(req.Pin); err != nil {
+		return err
+	}
 
-// handleCreateAccount creates a new account and stores it
-func (s *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request) error {
-	// Decode the request body to create an account
-	req := new(CreateAccountRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	// Setting a PIN requires the account password
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
 		return err
 	}
+	if !account.ValidPassword(req.Password) {
+		return validationErrorf("not authenticated")
+	}
 
-	// Create a new account
-	account, err := NewAccount(req.FirstName, req.LastName, req.Password)
+	// Hash and store the new PIN
+	encpin, err := bcrypt.GenerateFromPassword([]byte(req.Pin), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	// Store the account in the storage
-	if err := s.store.CreateAccount(account); err != nil {
+	if err := s.store.UpdatePin(r.Context(), id, string(encpin)); err != nil {
 		return err
 	}
+	s.pinLockout.Reset(strconv.Itoa(id))
 
-	// Send the created account as JSON response
-	return WriteJSON(w, http.StatusOK, account)
+	return WriteJSON(w, http.StatusOK, map[string]bool{"pinEnrolled": true})
 }
 
-// handleDeleteAccount deletes an account by its ID
-func (s *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
-	// Get the account ID from the URL
+// handleChangePassword replaces the password of the account, which requires the current one
+func (s *APIServer) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
 	id, err := getID(r)
 	if err != nil {
 		return err
 	}
 
-	// Delete the account from the storage
-	if err := s.store.DeleteAccount(id); err != nil {
+	// Decode the request body
+	req := new(ChangePasswordRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
 		return err
 	}
 
-	// Send a confirmation response
-	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": id})
+	// A stolen token alone is not enough to take over the account
+	if !accountFromContext(r).ValidPassword(req.OldPassword) {
+		return validationErrorf("old password is incorrect")
+	}
+
+	// Hash and store the new password
+	encpw, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdatePassword(r.Context(), id, string(encpw)); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"passwordChanged": true})
+}
+
+// verifyTransferPin requires the sender's transaction PIN for transfers above the configured threshold
+// Wrong PINs count toward a lockout of the sender's high-value transfers
+func (s *APIServer) verifyTransferPin(sender *Account, req *TransferRequest) error {
+	if s.config.PinThreshold <= 0 || int64(req.Amount) <= s.config.PinThreshold {
+		return nil
+	}
+
+	key := strconv.Itoa(sender.ID)
+	if locked, _ := s.pinLockout.Locked(key); locked {
+		return &TransferError{Code: "pin_locked", Message: "too many wrong PINs, try again later"}
+	}
+	if sender.EncryptedPin == "" {
+		return &TransferError{Code: "pin_required", Message: "transfers of this amount require a transaction PIN, please enroll one"}
+	}
+	if !sender.ValidPin(req.Pin) {
+		s.pinLockout.Fail(key)
+		return &TransferError{Code: "invalid_pin", Message: "invalid transaction PIN"}
+	}
+
+	s.pinLockout.Reset(key)
+	return nil
 }
 
-// handleTransfer handles the transfer request and sends the transfer details as the response
+// handleTransfer moves money from the authenticated account and sends a receipt as the response
 func (s *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) error {
+	// The sender is the account of the JWT token
+	sender := accountFromContext(r)
+
 	// Decode the transfer request body
 	transferReq := new(TransferRequest)
-	if err := json.NewDecoder(r.Body).Decode(transferReq); err != nil {
+	if err := decodeJSON(r, transferReq); err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
-	// Send the transfer details as JSON response
-	return WriteJSON(w, http.StatusOK, transferReq)
+	// Convert a decimal amount to minor units of the account currency
+	if transferReq.AmountDecimal != "" {
+		if transferReq.Amount != 0 {
+			return validationErrorf("amount and amountDecimal are mutually exclusive")
+		}
+		amount, err := parseMoney(transferReq.AmountDecimal, s.currencyOf(sender), s.config.AmountMaxDecimals)
+		if err != nil {
+			return err
+		}
+		transferReq.Amount = amount
+	}
+
+	// Reject malformed recipient account numbers
+	if err := s.config.AccountNumbers.Validate(int64(transferReq.ToAccount)); err != nil {
+		return err
+	}
+
+	// High-value transfers require the transaction PIN
+	if err := s.verifyTransferPin(sender, transferReq); err != nil {
+		return err
+	}
+
+	// Large transfers from some account types, and unusually large ones when configured, wait for a second approver
+	held := s.requiresApproval(sender, int64(transferReq.Amount))
+	if !held && s.config.AnomalyHold {
+		var err error
+		if held, err = s.isAnomalous(r.Context(), s.store, sender.ID, int64(transferReq.Amount)); err != nil {
+			return err
+		}
+	}
+	if held {
+		pending, err := s.requestApproval(r.Context(), sender, transferReq)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusAccepted, pending)
+	}
+
+	// Move the money between the accounts
+	receipt, err := s.executeTransfer(r.Context(), sender.ID, transferReq)
+	if err != nil {
+		return err
+	}
+
+	// Send the transfer receipt as JSON response
+	return WriteJSON(w, http.StatusOK, receipt)
+}
+
+// decodeJSON decodes the JSON request body into v, rejecting fields v doesn't have so that a misspelled
+// field isn't silently ignored
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+
+	// The decoder reports unknown fields as `json: unknown field "name"`
+	const unknownField = "json: unknown field "
+	switch {
+	case err == nil || errors.Is(err, errRequestTooLarge):
+		return err
+	case strings.HasPrefix(err.Error(), unknownField):
+		return validationErrorf("unknown field %s in request body", strings.TrimPrefix(err.Error(), unknownField))
+	}
+	// Anything else the decoder reports is a malformed body
+	return &ValidationError{Message: err.Error()}
+}
+
+// Envelope is the body of every JSON response, so clients parse successes and failures alike
+type Envelope struct {
+	Data  any       `json:"data"`  // Payload of a successful response, null on failure
+	Error *ApiError `json:"error"` // Reason of a failed response, null on success
 }
 
-// WriteJSON sends a JSON response with the specified status and value
+// WriteJSON sends a JSON response with the specified status and the value as its data
+// The value is encoded before anything is written, so a value that fails to encode is logged and
+// answered with a 500 instead of a truncated body under the intended status
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	body, err := json.Marshal(Envelope{Data: v})
+	if err != nil {
+		log.Printf("encoding %T response failed: %v\n", v, err)
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(Envelope{Error: &ApiError{Message: "internal server error"}})
+	}
+	return writeBody(w, status, body)
+}
+
+// WriteError sends a JSON response with the specified status and the error
+func WriteError(w http.ResponseWriter, status int, apiErr ApiError) error {
+	body, _ := json.Marshal(Envelope{Error: &apiErr})
+	return writeBody(w, status, body)
+}
+
+// writeBody sends an encoded JSON response body with the specified status
+func writeBody(w http.ResponseWriter, status int, body []byte) error {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
 
-	return json.NewEncoder(w).Encode(v)
+	_, err := w.Write(append(body, '\n'))
+	return err
 }
 
-// createJWT creates a JWT token for the given account
-func createJWT(account *Account) (string, error) {
-	// Define the JWT claims
+// createJWT creates a JWT token for the given account signed with secret, valid for ttl
+// The lifetime is clamped to maxTTL so no misconfiguration can mint long-lived tokens
+func createJWT(account *Account, secret string, ttl, maxTTL time.Duration) (string, error) {
+	if ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	// Define the JWT claims, with a unique ID to revoke the token by
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := jwt.TimeFunc()
 	claims := &jwt.MapClaims{
-		"expiresAt":     15000,
+		"jti":           jti,
+		"iat":           now.Unix(),
+		"exp":           now.Add(ttl).Unix(),
 		"accountNumber": account.Number,
 	}
 
-	// Retrieve the secret key from environment variables
-	secret := os.Getenv("JWT_SECRET")
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign the token using the secret key
 	return token.SignedString([]byte(secret))
 }
 
+// methodNotAllowed sends a 405 response listing the allowed methods in the Allow header
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) error {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	return WriteError(w, http.StatusMethodNotAllowed, ApiError{Message: "method not allowed"})
+}
+
+// allowedMethodsHandler answers requests whose path is routed but not for their method with a 405
+// listing the methods the routes for the path accept
+func allowedMethodsHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := []string{}
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			methods, err := route.GetMethods()
+			if err != nil {
+				return nil
+			}
+			match := &mux.RouteMatch{}
+			if route.Match(r, match) || match.MatchErr == mux.ErrMethodMismatch {
+				allowed = append(allowed, methods...)
+			}
+			return nil
+		})
+		methodNotAllowed(w, allowed...)
+	})
+}
+
 // permissionDenied sends a permission denied response
-func permissionDenied(w http.ResponseWriter) {
-	WriteJSON(w, http.StatusForbidden, ApiError{Error: "permission denied"})
+// An expired token is reported as such, so clients know to log in again rather than give up
+func permissionDenied(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTokenExpired) {
+		WriteError(w, http.StatusForbidden, ApiError{Message: errTokenExpired.Error(), Code: "token_expired"})
+		return
+	}
+	WriteError(w, http.StatusForbidden, ApiError{Message: "permission denied"})
 }
 
 // withJWTAuth is a middleware that checks JWT authentication for the given handler function
-func withJWTAuth(handlerFunc http.HandlerFunc, s Storage) http.HandlerFunc {
+func withJWTAuth(handlerFunc http.HandlerFunc, s Storage, config *Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("calling JWT auth middleware")
+		debugf(config, r, "authenticating %s %s", r.Method, r.URL.Path)
+
+		// Time the authentication, up to calling the next handler
+		start := time.Now()
 
 		// Retrieve the token from the request header
 		tokenString := r.Header.Get("x-jwt-token")
-		token, err := validateJWT(tokenString)
+		token, err := validateJWT(r.Context(), s, tokenString, config.JWTSecret, config.JWTLeeway)
 		if err != nil {
-			permissionDenied(w)
+			permissionDenied(w, err)
 			return
 		}
 		if !token.Valid {
-			permissionDenied(w)
+			permissionDenied(w, nil)
 			return
 		}
 
-		// Get the user ID from the request
+		// Get the user ID from the request; a malformed ID is the client's mistake, not a lack of permission
 		userID, err := getID(r)
 		if err != nil {
-			permissionDenied(w)
+			WriteError(w, http.StatusBadRequest, ApiError{Message: err.Error()})
 			return
 		}
 
 		// Retrieve the account associated with the user ID
-		account, err := s.GetAccountByID(userID)
+		account, err := s.GetAccountByID(r.Context(), userID)
 		if err != nil {
-			permissionDenied(w)
+			permissionDenied(w, nil)
 			return
 		}
 
 		// Validate the token claims against the account number
-		claims := token.Claims.(jwt.MapClaims)
-		if account.Number != int64(claims["accountNumber"].(float64)) {
-			permissionDenied(w)
+		number, err := tokenAccountNumber(token)
+		if err != nil || account.Number != number {
+			permissionDenied(w, nil)
 			return
 		}
 
+		// Call the next handler function with the authenticated account
+		recordTiming(r.Context(), "auth", start)
+		handlerFunc(w, withAccount(r, account))
+	}
+}
+
+// withAuth is a middleware that resolves the account of the JWT token for routes that don't address an
+// account by ID, passing it on in the request context
+func withAuth(handlerFunc http.HandlerFunc, s Storage, config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticatedAccount(r, s, config)
 		if err != nil {
-			WriteJSON(w, http.StatusForbidden, ApiError{Error: "invalid token"})
+			permissionDenied(w, err)
 			return
 		}
+		handlerFunc(w, withAccount(r, account))
+	}
+}
+
+// accountKey is the context key of the account authenticated by withJWTAuth or withAuth
+type accountKey struct{}
+
+// withAccount returns the request with the authenticated account stored in its context
+func withAccount(r *http.Request, account *Account) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), accountKey{}, account))
+}
+
+// accountFromContext returns the account authenticated for the request, nil on routes without authentication
+func accountFromContext(r *http.Request) *Account {
+	account, _ := r.Context().Value(accountKey{}).(*Account)
+	return account
+}
+
+// currencyOf returns the currency of the account; accounts stored before currencies existed hold the default one
+func (s *APIServer) currencyOf(acc *Account) string {
+	if acc.Currency == "" {
+		return s.config.DefaultCurrency
+	}
+	return acc.Currency
+}
+
+// presentAccounts fills in the display fields of accounts about to be sent in a response
+func (s *APIServer) presentAccounts(accounts ...*Account) {
+	for _, acc := range accounts {
+		acc.Currency = s.currencyOf(acc)
+		acc.BalanceDisplay = formatMoney(acc.Balance, acc.Currency, s.config.DisplayLocale)
+
+		// Expose the check digit so clients can validate numbers before submitting them; numbers
+		// assigned before Luhn was enabled, or of another length, carry no check digit to expose
+		if s.config.AccountNumbers.Luhn && s.config.AccountNumbers.Validate(acc.Number) == nil {
+			checkDigit := acc.Number % 10
+			acc.CheckDigit = &checkDigit
+		}
+	}
+}
+
+// presentTransactions fills in the display fields of the account's transactions about to be sent in a response
+func (s *APIServer) presentTransactions(acc *Account, transactions []*Transaction) {
+	for _, t := range transactions {
+		t.AmountDisplay = formatMoney(t.Amount, s.currencyOf(acc), s.config.DisplayLocale)
+	}
+}
 
-		// Call the next handler function
-		handlerFunc(w, r)
+// authenticatedAccount resolves the account owning the JWT token sent with the request
+func authenticatedAccount(r *http.Request, s Storage, config *Config) (*Account, error) {
+	defer recordTiming(r.Context(), "auth", time.Now())
+
+	token, err := validateJWT(r.Context(), s, r.Header.Get("x-jwt-token"), config.JWTSecret, config.JWTLeeway)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	// Look up the account referenced by the token claims
+	number, err := tokenAccountNumber(token)
+	if err != nil {
+		return nil, err
 	}
+
+	return s.GetAccountByNumber(r.Context(), int(number))
 }
 
-// validateJWT parses and validates a JWT token
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET")
+// tokenAccountNumber returns the account number a validated JWT token was issued for
+func tokenAccountNumber(token *jwt.Token) (int64, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, fmt.Errorf("invalid token claims")
+	}
+	number, ok := claims["accountNumber"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("invalid token claims")
+	}
+	return int64(number), nil
+}
 
-	// Parse the token and verify the signing method
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+// validateJWT parses and validates a JWT token signed with secret
+// The exp, nbf and iat claims are checked with the given leeway to tolerate clock skew with the issuer
+func validateJWT(ctx context.Context, store Storage, tokenString, secret string, leeway time.Duration) (*jwt.Token, error) {
+	// Parse the token and verify the signing method, leaving the time based claims to the checks below
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate the signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
@@ -277,31 +1409,187 @@ func validateJWT(tokenString string) (*jwt.Token, error) {
 
 		// Return the secret key for token verification
 		return []byte(secret), nil
-	})
+	}, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	now := jwt.TimeFunc()
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), false) {
+		return nil, errTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return nil, fmt.Errorf("token used before issued")
+	}
+
+	// Tokens issued before token IDs existed can't have been revoked
+	if jti, ok := claims["jti"].(string); ok {
+		revoked, err := store.IsTokenRevoked(ctx, jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errTokenRevoked
+		}
+	}
+
+	return token, nil
 }
 
 // apiFunc is a type alias for functions that handle HTTP requests and return an error
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
-// ApiError represents an error response
+// ApiError represents the error of a failed response
 type ApiError struct {
-	Error string `json:"error"`
+	Message string `json:"message"`        // Human readable reason
+	Code    string `json:"code,omitempty"` // Machine readable reason, if the error has one
 }
 
-// makeHTTPHandleFunc wraps an apiFunc to handle HTTP requests and send error responses
+// makeHTTPHandleFunc wraps an apiFunc to handle HTTP requests and send error responses with a matching status
 func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		err := f(w, r)
+		if err == nil {
+			return
+		}
+
+		status := errorStatus(err)
+		if seconds := retryAfter(err); seconds != "" {
+			w.Header().Set("Retry-After", seconds)
+		}
+		switch {
+		case status == statusClientClosedRequest:
+			// The client is gone, so there is nobody to send a body to and nothing to log
+			w.WriteHeader(status)
+		case status == http.StatusInternalServerError:
+			// The details may be raw database errors, which are for the logs only
+			logRequestf(r, "%s %s failed: %v", r.Method, r.URL.Path, err)
+			WriteError(w, status, ApiError{Message: "internal server error"})
+		case status > http.StatusInternalServerError:
+			logRequestf(r, "%s %s failed: %v", r.Method, r.URL.Path, err)
+			WriteError(w, status, ApiError{Message: http.StatusText(status)})
+		default:
+			WriteError(w, status, ApiError{Message: err.Error(), Code: errorCode(err)})
+		}
+	}
+}
+
+// parseIDs parses a comma separated list of account ids
+func parseIDs(idsStr string) ([]int, error) {
+	ids := []int{}
+	for _, idStr := range strings.Split(idsStr, ",") {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
 		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, validationErrorf("invalid id given %s", idStr)
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, validationErrorf("no ids given")
+	}
+	return ids, nil
+}
+
+// defaultAccountPageSize is the number of accounts listed when the client doesn't ask for a limit
+const defaultAccountPageSize = 25
+
+// defaultTransactionPageSize is the number of transactions listed by cursor when the client doesn't ask for a limit
+const defaultTransactionPageSize = 50
+
+// parsePage parses the optional limit and offset query parameters; a missing limit is returned as 0, meaning no limit
+func parsePage(r *http.Request) (limit, offset int, err error) {
+	query := r.URL.Query()
+	if str := query.Get("limit"); str != "" {
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit <= 0 {
+			return 0, 0, validationErrorf("invalid limit given %s", str)
+		}
+	}
+	if str := query.Get("offset"); str != "" {
+		offset, err = strconv.Atoi(str)
+		if err != nil || offset < 0 {
+			return 0, 0, validationErrorf("invalid offset given %s", str)
+		}
+	}
+	return limit, offset, nil
+}
+
+// parseCursor parses the cursor of a listing paged by cursor, e.g. ?cursor=MjU, and reports whether one was given;
+// an empty ?cursor= asks for the first page
+func parseCursor(r *http.Request) (id int, ok bool, err error) {
+	query := r.URL.Query()
+	if !query.Has("cursor") {
+		return 0, false, nil
+	}
+	if query.Has("offset") {
+		return 0, false, validationErrorf("cursor and offset can't be combined")
 	}
+
+	str := query.Get("cursor")
+	if str == "" {
+		return 0, true, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(str)
+	if err == nil {
+		id, err = strconv.Atoi(string(raw))
+	}
+	if err != nil || id <= 0 {
+		return 0, false, validationErrorf("invalid cursor given %s", str)
+	}
+	return id, true, nil
+}
+
+// encodeCursor returns the opaque cursor of the page following the row with the given ID
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// parseAccountFilter parses the includeDeleted flag and the createdAfter and createdBefore RFC 3339 timestamps
+// of an account listing, e.g. ?createdAfter=2024-01-01T00:00:00Z
+func parseAccountFilter(r *http.Request) (filter AccountFilter, err error) {
+	query := r.URL.Query()
+	if str := query.Get("includeDeleted"); str != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(str); err != nil {
+			return filter, validationErrorf("invalid includeDeleted given %s", str)
+		}
+	}
+	if str := query.Get("createdAfter"); str != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, str); err != nil {
+			return filter, validationErrorf("invalid createdAfter given %s, expected an RFC 3339 timestamp", str)
+		}
+	}
+	if str := query.Get("createdBefore"); str != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, str); err != nil {
+			return filter, validationErrorf("invalid createdBefore given %s, expected an RFC 3339 timestamp", str)
+		}
+	}
+	if !filter.CreatedAfter.IsZero() && !filter.CreatedBefore.IsZero() && filter.CreatedAfter.After(filter.CreatedBefore) {
+		return filter, validationErrorf("createdAfter must not be later than createdBefore")
+	}
+
+	// Creation times are stored in UTC
+	filter.CreatedAfter, filter.CreatedBefore = filter.CreatedAfter.UTC(), filter.CreatedBefore.UTC()
+	return filter, nil
 }
 
+// getID extracts the account id from the URL path
 func getID(r *http.Request) (int, error) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return id, fmt.Errorf("invalid id given %s", idStr)
+		return id, validationErrorf("invalid id given %s", idStr)
 	}
 	return id, nil
 }
@@ -0,0 +1,1545 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJWTSecret is the key the tests sign their JWT tokens with
+const testJWTSecret = "test-secret-at-least-32-characters"
+
+// newTestConfig returns the default configuration used by the handler tests
+func newTestConfig(t *testing.T) *Config {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	config, err := LoadConfig()
+	assert.Nil(t, err)
+	return config
+}
+
+// newTestServer creates an APIServer backed by an in-memory store
+func newTestServer(config *Config) (*APIServer, *memStore) {
+	store := newMemStore()
+	return NewAPIServer(":0", store, config), store
+}
+
+// newTestAccount stores a new account with the given role and returns it with a JWT token
+func newTestAccount(t *testing.T, store Storage, role string) (*Account, string) {
+	acc, err := NewAccount("test", "user", "hunter88888", AccountType{Name: "checking"})
+	assert.Nil(t, err)
+	acc.Role = role
+	acc.Number = AccountNumberPolicy{Digits: 6}.Generate()
+	assert.Nil(t, store.CreateAccount(context.Background(), acc))
+
+	token, err := createJWT(acc, testJWTSecret, time.Hour, time.Hour)
+	assert.Nil(t, err)
+	return acc, token
+}
+
+// recordingEmailSender keeps the sent emails so tests can read the tokens they carry
+type recordingEmailSender struct {
+	sent []string // Bodies of the sent emails
+}
+
+func (e *recordingEmailSender) Send(to, subject, body string) error {
+	e.sent = append(e.sent, body)
+	return nil
+}
+
+// serve runs the request through the server's router and returns the recorded response
+func serve(s *APIServer, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeData decodes the data of a successful JSON response into v
+func decodeData(t *testing.T, body io.Reader, v any) {
+	t.Helper()
+	envelope := struct {
+		Data  json.RawMessage `json:"data"`
+		Error *ApiError       `json:"error"`
+	}{}
+	assert.Nil(t, json.NewDecoder(body).Decode(&envelope))
+	assert.Nil(t, envelope.Error)
+	assert.Nil(t, json.Unmarshal(envelope.Data, v))
+}
+
+// decodeError decodes the error of a failed JSON response
+func decodeError(t *testing.T, body io.Reader) ApiError {
+	t.Helper()
+	envelope := Envelope{}
+	assert.Nil(t, json.NewDecoder(body).Decode(&envelope))
+	assert.Nil(t, envelope.Data)
+	if assert.NotNil(t, envelope.Error) {
+		return *envelope.Error
+	}
+	return ApiError{}
+}
+
+// TestDeprecatedEndpointSunsetHeader tests that a configured deprecated endpoint announces its sunset
+func TestDeprecatedEndpointSunsetHeader(t *testing.T) {
+	deprecations, err := parseDeprecations("/account#number=2027-01-01")
+	assert.Nil(t, err)
+	config := newTestConfig(t)
+	config.Deprecations = deprecations
+	server, store := newTestServer(config)
+	_, token := newTestAccount(t, store, RoleAdmin)
+
+	// The deprecated endpoint carries the Deprecation and Sunset headers
+	req := httptest.NewRequest("GET", "/account", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, "number", rec.Header().Get("X-Deprecated-Fields"))
+
+	sunset, err := time.Parse(http.TimeFormat, rec.Header().Get("Sunset"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), sunset)
+
+	// Other endpoints are left untouched
+	rec = serve(server, httptest.NewRequest("GET", "/login", nil))
+	assert.Empty(t, rec.Header().Get("Sunset"))
+}
+
+// TestGetAccountPaginates tests that the account list is paged with a default limit and reports the total
+func TestGetAccountPaginates(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	for i := 0; i < 29; i++ {
+		assert.Nil(t, store.CreateAccount(context.Background(), &Account{Number: int64(100000 + i)}))
+	}
+	_, token := newTestAccount(t, store, RoleAdmin)
+
+	get := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	list := func(query string) *AccountPage {
+		rec := get(query, token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := new(AccountPage)
+		decodeData(t, rec.Body, page)
+		return page
+	}
+
+	page := list("")
+	assert.Len(t, page.Accounts, defaultAccountPageSize)
+	assert.Equal(t, 30, page.Total)
+	assert.Equal(t, 1, page.Accounts[0].ID)
+
+	page = list("?limit=10&offset=25")
+	assert.Len(t, page.Accounts, 5)
+	assert.Equal(t, 26, page.Accounts[0].ID)
+	assert.Equal(t, AccountPage{Accounts: page.Accounts, Total: 30, Limit: 10, Offset: 25}, *page)
+
+	assert.Empty(t, list("?offset=30").Accounts)
+
+	rec := get("?limit=-1", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Only admins may list every holder's account
+	assert.Equal(t, http.StatusForbidden, get("", "").Code)
+	_, userToken := newTestAccount(t, store, RoleUser)
+	assert.Equal(t, http.StatusForbidden, get("", userToken).Code)
+}
+
+// TestGetAccountCursor tests that the account list pages by cursor and stays stable as accounts are added
+func TestGetAccountCursor(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, store.CreateAccount(context.Background(), &Account{Number: int64(100000 + i)}))
+	}
+	_, token := newTestAccount(t, store, RoleAdmin)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	list := func(query string) *AccountPage {
+		rec := get(query)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := new(AccountPage)
+		decodeData(t, rec.Body, page)
+		return page
+	}
+	ids := func(page *AccountPage) []int {
+		ids := []int{}
+		for _, acc := range page.Accounts {
+			ids = append(ids, acc.ID)
+		}
+		return ids
+	}
+
+	page := list("?limit=2&cursor=")
+	assert.Equal(t, []int{1, 2}, ids(page))
+	assert.NotEmpty(t, page.NextCursor)
+
+	// Accounts created meanwhile show up at the end instead of shifting the pages
+	assert.Nil(t, store.CreateAccount(context.Background(), &Account{Number: 100005}))
+	page = list("?limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{3, 4}, ids(page))
+	page = list("?limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{5, 6}, ids(page))
+	assert.Empty(t, page.NextCursor)
+
+	for _, query := range []string{"?cursor=abc", "?cursor=&offset=2"} {
+		rec := get(query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+// TestGetAccountCreatedRange tests that the account list can be narrowed down to a creation time window
+func TestGetAccountCreatedRange(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		acc := &Account{Number: int64(100000 + i), CreatedAt: start.AddDate(0, 0, i)}
+		assert.Nil(t, store.CreateAccount(context.Background(), acc))
+	}
+	_, token := newTestAccount(t, store, RoleAdmin)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	list := func(query string) *AccountPage {
+		rec := get(query)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := new(AccountPage)
+		decodeData(t, rec.Body, page)
+		return page
+	}
+
+	// Both bounds are inclusive
+	page := list("?createdAfter=2024-01-03T00:00:00Z&createdBefore=2024-01-06T00:00:00Z")
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, 3, page.Accounts[0].ID)
+
+	// Combined with paging, and with offsets other than UTC
+	page = list("?createdAfter=2024-01-05T02:00:00%2B02:00&createdBefore=2024-12-31T00:00:00Z&limit=2&offset=1")
+	assert.Equal(t, 6, page.Total)
+	assert.Len(t, page.Accounts, 2)
+	assert.Equal(t, 6, page.Accounts[0].ID)
+
+	rec := get("?createdAfter=2024-01-01")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "expected an RFC 3339 timestamp")
+	rec = get("?createdAfter=2024-02-01T00:00:00Z&createdBefore=2024-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestBatchGetAccountsOmitsMissing tests that a batch lookup returns only the existing accounts
+func TestBatchGetAccountsOmitsMissing(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	admin, adminToken := newTestAccount(t, store, RoleAdmin)
+	user, userToken := newTestAccount(t, store, RoleUser)
+
+	// An admin gets every existing account of a mixed list
+	req := httptest.NewRequest("GET", "/accounts?ids=1,2,42", nil)
+	req.Header.Set("x-jwt-token", adminToken)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	accounts := []*Account{}
+	decodeData(t, rec.Body, &accounts)
+	assert.Len(t, accounts, 2)
+	assert.Equal(t, admin.ID, accounts[0].ID)
+	assert.Equal(t, user.ID, accounts[1].ID)
+
+	// A regular user only gets their own account back
+	req = httptest.NewRequest("GET", "/accounts?ids=1,2,42", nil)
+	req.Header.Set("x-jwt-token", userToken)
+	rec = serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	accounts = []*Account{}
+	decodeData(t, rec.Body, &accounts)
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, user.ID, accounts[0].ID)
+
+	// Unauthenticated callers are turned away
+	rec = serve(server, httptest.NewRequest("GET", "/accounts?ids=1", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+// TestCreateSavingsAccountInheritsTypeRules tests that a new account gets the rules of its configured type
+func TestCreateSavingsAccountInheritsTypeRules(t *testing.T) {
+	config := newTestConfig(t)
+	assert.Nil(t, parseAccountTypes("savings:25000:0.035", config.AccountTypes))
+	server, _ := newTestServer(config)
+
+	body := `{"firstName":"a","lastName":"b","password":"hunter88888","type":"savings"}`
+	rec := serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	acc := new(Account)
+	decodeData(t, rec.Body, acc)
+	assert.Equal(t, "savings", acc.Type)
+	assert.Equal(t, Money(25000), acc.MinBalance)
+	assert.Equal(t, 0.035, acc.InterestRate)
+
+	// Unknown types are rejected
+	body = `{"firstName":"a","lastName":"b","password":"hunter88888","type":"crypto"}`
+	rec = serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// So are weak passwords, with the reason
+	body = `{"firstName":"a","lastName":"b","password":"abc"}`
+	rec = serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "password must have at least 8 characters")
+}
+
+// TestUnknownJSONFieldRejected tests that a misspelled request field is reported instead of ignored
+func TestUnknownJSONFieldRejected(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	body := fmt.Sprintf(`{"toAccount":%d,"amount":100,"memmo":"rent"}`, recipient.Number)
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `unknown field \"memmo\" in request body`)
+
+	// Nothing was transferred
+	acc, err := store.GetAccountByID(context.Background(), sender.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, Money(1000), acc.Balance)
+}
+
+// numberRaceStore lets a concurrent signup take each of the first races numbers right after they were checked
+type numberRaceStore struct {
+	*memStore
+	races int
+}
+
+func (s *numberRaceStore) AccountNumberExists(ctx context.Context, number int64) (bool, error) {
+	if s.races == 0 {
+		return s.memStore.AccountNumberExists(ctx, number)
+	}
+	s.races--
+	acc, err := NewAccount("other", "user", "hunter88888", AccountType{Name: "checking"})
+	if err != nil {
+		return false, err
+	}
+	acc.Number = number
+	return false, s.memStore.CreateAccount(ctx, acc)
+}
+
+// TestCreateAccountNumberConflict tests that a number lost to a concurrent signup is replaced, and that
+// persistent conflicts are reported as 409
+func TestCreateAccountNumberConflict(t *testing.T) {
+	store := &numberRaceStore{memStore: newMemStore(), races: 1}
+	server := NewAPIServer(":0", store, newTestConfig(t))
+
+	body := `{"firstName":"a","lastName":"b","password":"hunter88888"}`
+	rec := serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	accounts, err := store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, accounts, 2)
+	assert.NotEqual(t, accounts[0].Number, accounts[1].Number)
+
+	store.races = maxCreateAttempts
+	rec = serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "pq:")
+}
+
+// TestMalformedAccountNumberRejected tests that malformed account numbers never reach the storage
+func TestMalformedAccountNumberRejected(t *testing.T) {
+	config := newTestConfig(t)
+	config.AccountNumbers = AccountNumberPolicy{Digits: 6, Luhn: true}
+	server, store := newTestServer(config)
+
+	// Too long, negative and failing the Luhn check
+	for _, number := range []string{"12345678", "-5", "123456"} {
+		body := `{"number":` + number + `,"password":"hunter88888"}`
+		rec := serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid account number")
+	}
+	assert.Equal(t, 0, store.numberLookups)
+
+	// A well-formed number is looked up
+	number := config.AccountNumbers.Generate()
+	body := `{"number":` + strconv.FormatInt(number, 10) + `,"password":"hunter88888"}`
+	serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	assert.Equal(t, 1, store.numberLookups)
+}
+
+// TestLoginByEmail tests that accounts log in by either their number or their email address, but not both
+func TestLoginByEmail(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	server.sleep = func(ctx context.Context, d time.Duration) {}
+	acc, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[acc.ID].Email = "a@example.com"
+	number := strconv.FormatInt(acc.Number, 10)
+
+	login := func(body string) *httptest.ResponseRecorder {
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	}
+
+	for _, body := range []string{
+		`{"email":"a@example.com","password":"hunter88888"}`,
+		`{"number":` + number + `,"password":"hunter88888"}`,
+	} {
+		rec := login(body)
+		assert.Equal(t, http.StatusOK, rec.Code, body)
+		resp := new(LoginResponse)
+		decodeData(t, rec.Body, resp)
+		assert.Equal(t, acc.Number, resp.Number)
+	}
+
+	rec := login(`{"email":"a@example.com","password":"wrong-password"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not authenticated")
+
+	rec = login(`{"number":` + number + `,"email":"a@example.com","password":"hunter88888"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not both")
+
+	rec = login(`{"password":"hunter88888"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "number or email is required")
+}
+
+// TestUnknownLoginLooksLikeWrongPassword tests that logins can't tell unknown accounts from wrong passwords
+func TestUnknownLoginLooksLikeWrongPassword(t *testing.T) {
+	config := newTestConfig(t)
+	server, store := newTestServer(config)
+	server.sleep = func(ctx context.Context, d time.Duration) {}
+	acc, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[acc.ID].Email = "a@example.com"
+
+	login := func(body string) *httptest.ResponseRecorder {
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	}
+
+	wrongPassword := login(`{"email":"a@example.com","password":"wrong-password"}`)
+	assert.Equal(t, http.StatusBadRequest, wrongPassword.Code)
+	for _, body := range []string{
+		`{"email":"nobody@example.com","password":"wrong-password"}`,
+		`{"number":` + strconv.FormatInt(config.AccountNumbers.Generate(), 10) + `,"password":"wrong-password"}`,
+	} {
+		rec := login(body)
+		assert.Equal(t, wrongPassword.Code, rec.Code, body)
+		assert.Equal(t, wrongPassword.Body.String(), rec.Body.String(), body)
+	}
+}
+
+// TestFailedLoginsIncurIncreasingDelays tests that consecutive failed logins are delayed exponentially up to the cap,
+// and that a success or a quiet window resets the penalty
+func TestFailedLoginsIncurIncreasingDelays(t *testing.T) {
+	config := newTestConfig(t)
+	config.LoginPenaltyBase = time.Second
+	config.LoginPenaltyMax = 5 * time.Second
+	config.LoginPenaltyWindow = time.Minute
+	config.LoginMaxAttempts = 0 // Only the penalty is under test
+	server, store := newTestServer(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	delays := []time.Duration{}
+	server.sleep = func(ctx context.Context, d time.Duration) { delays = append(delays, d) }
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	login := func(password string) int {
+		body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"` + password + `"}`
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body))).Code
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusBadRequest, login("wrong"))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	// A successful login isn't delayed and resets the penalty
+	delays = nil
+	assert.Equal(t, http.StatusOK, login("hunter88888"))
+	assert.Equal(t, http.StatusBadRequest, login("wrong"))
+	assert.Equal(t, []time.Duration{time.Second}, delays)
+
+	// So does a quiet window
+	delays = nil
+	assert.Equal(t, http.StatusBadRequest, login("wrong"))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, http.StatusBadRequest, login("wrong"))
+	assert.Equal(t, []time.Duration{2 * time.Second, time.Second}, delays)
+}
+
+// TestFailedLoginPenaltyRejects tests that in reject mode logins during the penalty are refused with 429
+func TestFailedLoginPenaltyRejects(t *testing.T) {
+	config := newTestConfig(t)
+	config.LoginPenaltyBase = time.Second
+	config.LoginPenaltyMode = PenaltyReject
+	server, store := newTestServer(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	server.sleep = func(ctx context.Context, d time.Duration) { t.Fatal("reject mode must not delay responses") }
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"` + password + `"}`
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	}
+
+	assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+
+	// Even the right password is refused while the penalty lasts
+	rec := login("hunter88888")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "login_penalty")
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, login("hunter88888").Code)
+}
+
+// TestFailedLoginsLockAccount tests that consecutive failed logins lock the account number for the cooldown,
+// and that a successful login resets the count
+func TestFailedLoginsLockAccount(t *testing.T) {
+	config := newTestConfig(t)
+	config.LoginMaxAttempts = 3
+	config.LoginLockout = time.Minute
+	server, store := newTestServer(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	server.sleep = func(ctx context.Context, d time.Duration) {}
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"` + password + `"}`
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	}
+
+	// A success in between starts the count over
+	assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+	assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+	assert.Equal(t, http.StatusOK, login("hunter88888").Code)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+	}
+
+	// Even the right password is refused during the lockout
+	rec := login("hunter88888")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "too many attempts, try again later")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, login("hunter88888").Code)
+}
+
+// TestUpdateAccount tests that the owner can change the name on the account, but nobody else can
+func TestUpdateAccount(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	_, otherToken := newTestAccount(t, store, RoleUser)
+
+	update := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/account/%d", acc.ID), strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := update(token, `{"firstName":" Jane "}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, "Jane", resp.FirstName)
+	assert.Equal(t, "user", resp.LastName)
+
+	stored, err := store.GetAccountByID(context.Background(), acc.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", stored.FirstName)
+
+	// Empty and blank updates are rejected
+	assert.Equal(t, http.StatusBadRequest, update(token, `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, update(token, `{"lastName":"  "}`).Code)
+
+	// Other account holders can't rename the account
+	assert.Equal(t, http.StatusForbidden, update(otherToken, `{"firstName":"Mallory"}`).Code)
+	stored, err = store.GetAccountByID(context.Background(), acc.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", stored.FirstName)
+}
+
+// TestChangePassword tests that the password can be changed with the current one, after which only the new one logs in
+func TestChangePassword(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	server.sleep = func(ctx context.Context, d time.Duration) {}
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	change := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/password", acc.ID), strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	login := func(password string) int {
+		body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"` + password + `"}`
+		return serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body))).Code
+	}
+
+	// The current password is required and the new one must be strong enough
+	rec := change(`{"oldPassword":"wrong-password","newPassword":"correct-horse"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "old password is incorrect")
+	rec = change(`{"oldPassword":"hunter88888","newPassword":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "password must have at least")
+
+	assert.Equal(t, http.StatusOK, change(`{"oldPassword":"hunter88888","newPassword":"correct-horse"}`).Code)
+	assert.Equal(t, http.StatusOK, login("correct-horse"))
+	assert.Equal(t, http.StatusBadRequest, login("hunter88888"))
+}
+
+// TestJWTAuthMalformedID tests that a malformed account ID is reported as a bad request rather than as forbidden
+func TestJWTAuthMalformedID(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	_, otherToken := newTestAccount(t, store, RoleUser)
+
+	get := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account/"+id, nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := get("abc", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid id given abc")
+	assert.Equal(t, http.StatusForbidden, get(strconv.Itoa(acc.ID), otherToken).Code)
+
+	// Without a valid token nothing is revealed about the ID
+	assert.Equal(t, http.StatusForbidden, get("abc", "").Code)
+}
+
+// TestAuthPassesAccountInContext tests that the authentication middlewares hand the account of the token
+// to the handler
+func TestAuthPassesAccountInContext(t *testing.T) {
+	_, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	config := newTestConfig(t)
+
+	var got *Account
+	handler := func(w http.ResponseWriter, r *http.Request) { got = accountFromContext(r) }
+
+	router := mux.NewRouter()
+	router.HandleFunc("/account/{id}", withJWTAuth(handler, store, config))
+	router.HandleFunc("/me", withAuth(handler, store, config))
+
+	for _, path := range []string{fmt.Sprintf("/account/%d", acc.ID), "/me"} {
+		got = nil
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("x-jwt-token", token)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if assert.NotNil(t, got, path) {
+			assert.Equal(t, acc.ID, got.ID)
+		}
+	}
+
+	// Unauthenticated requests never reach the handler
+	got = nil
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/me", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Nil(t, got)
+}
+
+// TestResponseEnvelope tests that successes, handler errors and unknown routes share the same body shape
+func TestResponseEnvelope(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	me := new(Account)
+	decodeData(t, rec.Body, me)
+	assert.Equal(t, acc.ID, me.ID)
+
+	rec = serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(`{"password":"hunter88888"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"data":null,"error":{"message":"number or email is required"}}`, rec.Body.String())
+
+	rec = serve(server, httptest.NewRequest("GET", "/nowhere", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "not found", decodeError(t, rec.Body).Message)
+}
+
+// TestMethodNotAllowed tests that unsupported methods get a 405 listing the allowed ones
+func TestMethodNotAllowed(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	rec := serve(server, httptest.NewRequest("DELETE", "/login", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "POST", rec.Header().Get("Allow"))
+
+	rec = serve(server, httptest.NewRequest("PATCH", "/account", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, POST", rec.Header().Get("Allow"))
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec = serve(server, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, PUT, DELETE", rec.Header().Get("Allow"))
+
+	// The router rejects the method before the handler authenticates the request
+	rec = serve(server, httptest.NewRequest("GET", fmt.Sprintf("/account/%d/freeze", acc.ID), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "PATCH", rec.Header().Get("Allow"))
+	rec = serve(server, httptest.NewRequest("GET", fmt.Sprintf("/admin/account/%d/rotate-number", acc.ID), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "POST", rec.Header().Get("Allow"))
+}
+
+// TestLogoutRevokesToken tests that a token can't be used after logging out, while other tokens of the account can
+func TestLogoutRevokesToken(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	other, err := createJWT(acc, testJWTSecret, time.Hour, time.Hour)
+	assert.Nil(t, err)
+
+	get := func(token string) int {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req).Code
+	}
+	assert.Equal(t, http.StatusOK, get(token))
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"data":{"loggedOut":true},"error":null}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, get(token))
+	assert.Equal(t, http.StatusOK, get(other))
+
+	// The revocation lasts as long as the token would have
+	for _, expiresAt := range store.revoked {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+	}
+
+	// Logging out twice fails like any other use of the token
+	req = httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set("x-jwt-token", token)
+	assert.Equal(t, http.StatusForbidden, serve(server, req).Code)
+}
+
+// TestRevocationPurge tests that the sweeper drops the revocations of expired tokens and keeps the others
+func TestRevocationPurge(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	assert.Nil(t, store.RevokeToken(context.Background(), "expired", now.Add(-time.Minute)))
+	assert.Nil(t, store.RevokeToken(context.Background(), "live", now.Add(time.Minute)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.RunRevocationPurge(ctx, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		revoked, err := store.IsTokenRevoked(context.Background(), "expired")
+		return err == nil && !revoked
+	}, time.Second, 10*time.Millisecond)
+	revoked, err := store.IsTokenRevoked(context.Background(), "live")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+}
+
+// TestSearchAccounts tests that admins can search accounts by holder name, and nobody else can
+func TestSearchAccounts(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	_, adminToken := newTestAccount(t, store, RoleAdmin)
+	acc, userToken := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateAccount(context.Background(), acc.ID, "Ada", "Lovelace"))
+
+	search := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account/search?q="+url.QueryEscape(query), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := search("ada love", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	accounts := []*Account{}
+	decodeData(t, rec.Body, &accounts)
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, acc.ID, accounts[0].ID)
+
+	rec = search("LACE", adminToken)
+	decodeData(t, rec.Body, &accounts)
+	assert.Len(t, accounts, 1)
+
+	assert.Equal(t, http.StatusBadRequest, search(" ", adminToken).Code)
+	assert.Equal(t, http.StatusForbidden, search("ada", userToken).Code)
+}
+
+// TestGetMe tests that the account of the token is returned without knowing its ID
+func TestGetMe(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	newTestAccount(t, store, RoleUser)
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, acc.ID, resp.ID)
+	assert.Equal(t, acc.Number, resp.Number)
+
+	rec = serve(server, httptest.NewRequest("GET", "/me", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+// TestGetBalance tests that the balance endpoint returns only the number, balance and currency
+func TestGetBalance(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 1234))
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/balance", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := map[string]interface{}{}
+	decodeData(t, rec.Body, &resp)
+	assert.Equal(t, map[string]interface{}{
+		"number":   float64(acc.Number),
+		"balance":  float64(1234),
+		"currency": "USD",
+	}, resp)
+}
+
+// TestGetAccountByNumber tests that account holders can look up their own account by number, and only their own
+func TestGetAccountByNumber(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	other, _ := newTestAccount(t, store, RoleUser)
+
+	get := func(number int64, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/by-number/%d", number), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := get(acc.Number, token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, acc.ID, resp.ID)
+
+	assert.Equal(t, http.StatusForbidden, get(other.Number, token).Code)
+	assert.Equal(t, http.StatusForbidden, get(acc.Number, "").Code)
+
+	// Numbers of the wrong length are rejected before the lookup
+	rec = get(12, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "must have 6 digits")
+
+	// A deleted account is no longer found
+	assert.Nil(t, store.DeleteAccount(context.Background(), acc.ID))
+	rec = get(acc.Number, token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not found")
+}
+
+// TestCreateAccountEmail tests that new accounts need a plausible email address that no other account uses
+func TestCreateAccountEmail(t *testing.T) {
+	server, _ := newTestServer(newTestConfig(t))
+	create := func(email string) *httptest.ResponseRecorder {
+		body := `{"firstName":"a","lastName":"b","password":"hunter88888","email":"` + email + `"}`
+		return serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	}
+
+	for _, email := range []string{"a", "a@", "@example.com", "a@example", "a b@example.com", "a@@example.com"} {
+		rec := create(email)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, email)
+		assert.Contains(t, rec.Body.String(), "email address is invalid", email)
+	}
+
+	rec := create("a@example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	acc := new(Account)
+	decodeData(t, rec.Body, acc)
+	assert.Equal(t, "a@example.com", acc.Email)
+
+	// A second account can't take the same address
+	rec = create("a@example.com")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "email address is already in use")
+}
+
+// TestActivationUnblocksTransfers tests that an unactivated account can't transfer until its emailed token is used
+func TestActivationUnblocksTransfers(t *testing.T) {
+	config := newTestConfig(t)
+	config.RequireActivation = true
+	server, store := newTestServer(config)
+	email := &recordingEmailSender{}
+	server.email = email
+	recipient, _ := newTestAccount(t, store, RoleUser)
+
+	// Creating an account emails an activation link
+	body := `{"firstName":"a","lastName":"b","password":"hunter88888","email":"a@example.com"}`
+	rec := serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	acc := new(Account)
+	decodeData(t, rec.Body, acc)
+	assert.False(t, acc.Activated)
+	assert.Len(t, email.sent, 1)
+
+	// The unactivated account can't transfer
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 1000))
+	token, err := createJWT(acc, testJWTSecret, time.Hour, time.Hour)
+	assert.Nil(t, err)
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "account_not_activated")
+
+	// Following the link activates the account and unblocks transfers
+	_, link, _ := strings.Cut(email.sent[0], ": ")
+	rec = serve(server, httptest.NewRequest("GET", link, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The token can't be used twice
+	rec = serve(server, httptest.NewRequest("GET", link, nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// requestPasswordReset requests a reset email for the address and returns the emailed token, empty when none was sent
+func requestPasswordReset(t *testing.T, server *APIServer, email *recordingEmailSender, address string) string {
+	sent := len(email.sent)
+	body := `{"email":"` + address + `"}`
+	rec := serve(server, httptest.NewRequest("POST", "/password-reset/request", strings.NewReader(body)))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	if len(email.sent) == sent {
+		return ""
+	}
+	_, token, _ := strings.Cut(email.sent[sent], "with this token: ")
+	return token
+}
+
+// confirmPasswordReset sets a new password with the reset token
+func confirmPasswordReset(server *APIServer, token, password string) *httptest.ResponseRecorder {
+	body := `{"token":"` + token + `","password":"` + password + `"}`
+	return serve(server, httptest.NewRequest("POST", "/password-reset/confirm", strings.NewReader(body)))
+}
+
+// TestPasswordReset tests that an emailed reset token sets a new password
+func TestPasswordReset(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	email := &recordingEmailSender{}
+	server.email = email
+	acc, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[acc.ID].Email = "a@example.com"
+
+	// Unknown addresses get the same response, but no email
+	assert.Empty(t, requestPasswordReset(t, server, email, "nobody@example.com"))
+
+	token := requestPasswordReset(t, server, email, "a@example.com")
+	assert.NotEmpty(t, token)
+	assert.NotEqual(t, token, store.tokens[0].TokenHash)
+
+	rec := confirmPasswordReset(server, token, "new-password")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	acc, _ = store.GetAccountByID(context.Background(), acc.ID)
+	assert.True(t, acc.ValidPassword("new-password"))
+	assert.False(t, acc.ValidPassword("hunter88888"))
+}
+
+// TestPasswordResetTokenSingleUseAndExpiring tests that reused and expired reset tokens are rejected
+func TestPasswordResetTokenSingleUseAndExpiring(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	email := &recordingEmailSender{}
+	server.email = email
+	acc, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[acc.ID].Email = "a@example.com"
+
+	// A used token can't be used again
+	token := requestPasswordReset(t, server, email, "a@example.com")
+	assert.Equal(t, http.StatusOK, confirmPasswordReset(server, token, "first-password").Code)
+	rec := confirmPasswordReset(server, token, "second-password")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid or expired token")
+
+	// An expired token is rejected
+	token = requestPasswordReset(t, server, email, "a@example.com")
+	store.tokens[1].ExpiresAt = time.Now().Add(-time.Minute)
+	rec = confirmPasswordReset(server, token, "second-password")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	acc, _ = store.GetAccountByID(context.Background(), acc.ID)
+	assert.True(t, acc.ValidPassword("first-password"))
+}
+
+// TestEmailFlowsNeedDelivery tests that token emails are refused while emails only go to the log
+func TestEmailFlowsNeedDelivery(t *testing.T) {
+	config := newTestConfig(t)
+	server, store := newTestServer(config)
+	acc, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[acc.ID].Email = "a@example.com"
+
+	body := `{"email":"a@example.com"}`
+	rec := serve(server, httptest.NewRequest("POST", "/password-reset/request", strings.NewReader(body)))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, store.tokens)
+
+	// The server doesn't start with activation required
+	config.RequireActivation = true
+	assert.EqualError(t, server.Run(context.Background()), "REQUIRE_ACTIVATION needs an email sender that delivers the activation links")
+}
+
+// TestJWTLeeway tests that a recently expired token is accepted within the configured leeway
+func TestJWTLeeway(t *testing.T) {
+	claims := jwt.MapClaims{
+		"accountNumber": 100001,
+		"exp":           time.Now().Add(-10 * time.Second).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	assert.Nil(t, err)
+
+	_, err = validateJWT(context.Background(), newMemStore(), token, testJWTSecret, 30*time.Second)
+	assert.Nil(t, err)
+
+	_, err = validateJWT(context.Background(), newMemStore(), token, testJWTSecret, 0)
+	assert.Equal(t, errTokenExpired, err)
+}
+
+// TestExpiredTokenRejected tests that an expired token is denied with a token expired error
+func TestExpiredTokenRejected(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, _ := newTestAccount(t, store, RoleUser)
+	claims := jwt.MapClaims{
+		"accountNumber": acc.Number,
+		"iat":           time.Now().Add(-time.Hour).Unix(),
+		"exp":           time.Now().Add(-time.Minute).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	assert.Nil(t, err)
+
+	// Both the middleware and the handlers resolving the caller themselves report the expiry
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil),
+		transferRequest(token, acc.Number, 1),
+	} {
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, ApiError{Message: "token expired", Code: "token_expired"}, decodeError(t, rec.Body))
+	}
+}
+
+// TestMalformedTokenClaims tests that a validly signed token with a missing or mistyped account number is denied
+func TestMalformedTokenClaims(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	for _, claims := range []jwt.MapClaims{
+		{"exp": time.Now().Add(time.Hour).Unix()},
+		{"accountNumber": fmt.Sprint(acc.Number), "exp": time.Now().Add(time.Hour).Unix()},
+	} {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+		assert.Nil(t, err)
+
+		for _, req := range []*http.Request{
+			httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil),
+			transferRequest(token, acc.Number, 1),
+		} {
+			req.Header.Set("x-jwt-token", token)
+			assert.Equal(t, http.StatusForbidden, serve(server, req).Code)
+		}
+	}
+}
+
+// TestRefreshToken tests that a valid token is exchanged for a fresh one while expired, malformed and orphaned ones aren't
+func TestRefreshToken(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/refresh", nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := refresh(token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(LoginResponse)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, acc.Number, resp.Number)
+	_, err := validateJWT(context.Background(), newMemStore(), resp.Token, testJWTSecret, 0)
+	assert.Nil(t, err)
+
+	// The refreshed token works like a fresh login
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", resp.Token)
+	assert.Equal(t, http.StatusOK, serve(server, req).Code)
+
+	// Expired tokens must log in again
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"accountNumber": acc.Number,
+		"exp":           time.Now().Add(-time.Minute).Unix(),
+	}).SignedString([]byte(testJWTSecret))
+	assert.Nil(t, err)
+	rec = refresh(expired)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "token_expired")
+
+	assert.Equal(t, http.StatusForbidden, refresh("not-a-token").Code)
+
+	// Tokens of deleted accounts are refused
+	assert.Nil(t, store.DeleteAccount(context.Background(), acc.ID))
+	assert.Equal(t, http.StatusForbidden, refresh(token).Code)
+}
+
+// TestSoftDeleteAccount tests that deleted accounts are hidden but kept, and listed to admins on request
+func TestSoftDeleteAccount(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	admin, adminToken := newTestAccount(t, store, RoleAdmin)
+
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	assert.Equal(t, http.StatusOK, serve(server, req).Code)
+
+	// The row is kept, but the account is gone for lookups
+	assert.NotNil(t, store.accounts[acc.ID].DeletedAt)
+	_, err := store.GetAccountByID(context.Background(), acc.ID)
+	assert.NotNil(t, err)
+	req = httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	assert.Equal(t, http.StatusForbidden, serve(server, req).Code)
+
+	list := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/account"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	page := new(AccountPage)
+	decodeData(t, list("", adminToken).Body, page)
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, admin.ID, page.Accounts[0].ID)
+
+	// Only admins may include the deleted accounts
+	assert.Equal(t, http.StatusForbidden, list("?includeDeleted=true", "").Code)
+	assert.Equal(t, http.StatusBadRequest, list("?includeDeleted=maybe", adminToken).Code)
+	rec := list("?includeDeleted=true", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	page = new(AccountPage)
+	decodeData(t, rec.Body, page)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, acc.ID, page.Accounts[0].ID)
+	assert.NotNil(t, page.Accounts[0].DeletedAt)
+}
+
+// TestServeDrainsInFlightRequests tests that shutting down waits for in-flight requests before serve returns
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	// Hold the login in flight until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.comparePassword = func(acc *Account, password string) bool {
+		close(started)
+		<-release
+		return acc.ValidPassword(password)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener) }()
+
+	status := make(chan int, 1)
+	go func() {
+		body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"hunter88888"}`
+		resp, err := http.Post("http://"+listener.Addr().String()+"/login", "application/json", strings.NewReader(body))
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	// Shutting down waits for the login to complete
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("serve returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-status)
+	assert.Nil(t, <-served)
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its key to PEM files
+func writeTestCertificate(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err = x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert
+}
+
+// TestServeTLS tests that the server speaks HTTPS when a certificate is configured
+func TestServeTLS(t *testing.T) {
+	config := newTestConfig(t)
+	certFile, keyFile, cert := writeTestCertificate(t)
+	config.TLSCertFile, config.TLSKeyFile = certFile, keyFile
+	server, _ := newTestServer(config)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener) }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/health")
+	assert.Nil(t, err)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	client.CloseIdleConnections()
+
+	cancel()
+	assert.Nil(t, <-served)
+}
+
+// TestWriteJSONUnencodableValue tests that a value failing to encode is answered with a 500 and a valid body
+func TestWriteJSONUnencodableValue(t *testing.T) {
+	rec := httptest.NewRecorder()
+	assert.Nil(t, WriteJSON(rec, http.StatusOK, map[string]any{"balance": 10, "callback": func() {}}))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	assert.Equal(t, "internal server error", decodeError(t, rec.Body).Message)
+}
+
+// TestAccountExposesCheckDigit tests that accounts expose the Luhn check digit of their number when enabled
+func TestAccountExposesCheckDigit(t *testing.T) {
+	config := newTestConfig(t)
+	config.AccountNumbers = AccountNumberPolicy{Digits: 8, Luhn: true}
+	server, _ := newTestServer(config)
+
+	body := `{"firstName":"a","lastName":"b","password":"hunter88888"}`
+	rec := serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	acc := new(Account)
+	decodeData(t, rec.Body, acc)
+	assert.NotNil(t, acc.CheckDigit)
+	assert.Equal(t, luhnCheckDigit(acc.Number/10), *acc.CheckDigit)
+
+	// Numbers assigned before Luhn was enabled don't end in a valid check digit
+	legacy := &Account{Number: 1234567*10 + (luhnCheckDigit(1234567)+1)%10}
+	server.presentAccounts(legacy)
+	assert.Nil(t, legacy.CheckDigit)
+
+	// Without Luhn numbers there is no check digit to expose
+	server, _ = newTestServer(newTestConfig(t))
+	rec = serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.NotContains(t, rec.Body.String(), "checkDigit")
+}
+
+// TestBatchGetAccountsProjection tests that admins get the full account detail and owners a summary
+func TestBatchGetAccountsProjection(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	_, adminToken := newTestAccount(t, store, RoleAdmin)
+	user, userToken := newTestAccount(t, store, RoleUser)
+
+	get := func(token string) map[string]any {
+		req := httptest.NewRequest("GET", "/accounts?ids="+strconv.Itoa(user.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		accounts := []map[string]any{}
+		decodeData(t, rec.Body, &accounts)
+		assert.Len(t, accounts, 1)
+		return accounts[0]
+	}
+
+	admin := get(adminToken)
+	owner := get(userToken)
+	for _, field := range []string{"id", "number", "balance", "balanceDisplay", "type"} {
+		assert.Equal(t, admin[field], owner[field], field)
+	}
+	for _, field := range []string{"role", "minBalance", "acceptsInbound", "activated", "email"} {
+		assert.Contains(t, admin, field)
+		assert.NotContains(t, owner, field)
+	}
+}
+
+// TestCreateJWTClampsLifetime tests that a token lifetime beyond the configured maximum is clamped
+func TestCreateJWTClampsLifetime(t *testing.T) {
+	acc := &Account{Number: 100001}
+
+	tokenString, err := createJWT(acc, testJWTSecret, 30*24*time.Hour, 24*time.Hour)
+	assert.Nil(t, err)
+
+	token, err := validateJWT(context.Background(), newMemStore(), tokenString, testJWTSecret, 0)
+	assert.Nil(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+	lifetime := time.Duration(claims["exp"].(float64)-claims["iat"].(float64)) * time.Second
+	assert.Equal(t, 24*time.Hour, lifetime)
+
+	// Shorter lifetimes are kept
+	tokenString, err = createJWT(acc, testJWTSecret, time.Minute, 24*time.Hour)
+	assert.Nil(t, err)
+	token, err = validateJWT(context.Background(), newMemStore(), tokenString, testJWTSecret, 0)
+	assert.Nil(t, err)
+	claims = token.Claims.(jwt.MapClaims)
+	assert.Equal(t, float64(60), claims["exp"].(float64)-claims["iat"].(float64))
+}
+
+// TestConcurrentIdenticalLoginsShareBcrypt tests that concurrent identical logins run the password check once
+func TestConcurrentIdenticalLoginsShareBcrypt(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, _ := newTestAccount(t, store, RoleUser)
+
+	// Hold the first password check until every other login joined it
+	const logins = 5
+	var compared int32
+	release := make(chan struct{})
+	server.comparePassword = func(acc *Account, password string) bool {
+		atomic.AddInt32(&compared, 1)
+		<-release
+		return acc.ValidPassword(password)
+	}
+
+	body := `{"number":` + strconv.FormatInt(acc.Number, 10) + `,"password":"hunter88888"}`
+	codes := make(chan int, logins)
+	for i := 0; i < logins; i++ {
+		go func() {
+			rec := serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+			codes <- rec.Code
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		server.logins.mu.Lock()
+		defer server.logins.mu.Unlock()
+		for _, c := range server.logins.calls {
+			return c.dups == logins-1
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	close(release)
+
+	for i := 0; i < logins; i++ {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&compared))
+
+	// Every login shared the check, which the metrics count
+	rec := httptest.NewRecorder()
+	server.metrics.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "gobank_logins_shared_total 5")
+}
+
+// TestDepositCreditsBalance tests that the owner can deposit a positive amount
+func TestDepositCreditsBalance(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	_, otherToken := newTestAccount(t, store, RoleUser)
+
+	deposit := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/deposit", acc.ID), strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := deposit(token, `{"amount":500}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, Money(500), resp.Balance)
+	assert.Equal(t, TransactionDeposit, store.transactions[0].Kind)
+
+	// Zero and negative amounts are rejected
+	for _, body := range []string{`{"amount":0}`, `{"amount":-5}`} {
+		rec = deposit(token, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "deposit amount must be positive")
+	}
+
+	// Only the owner can deposit
+	rec = deposit(otherToken, `{"amount":500}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	acc, _ = store.GetAccountByID(context.Background(), acc.ID)
+	assert.Equal(t, Money(500), acc.Balance)
+}
+
+// TestGetTransactionsPaginates tests that limit and offset page through the history newest first
+func TestGetTransactionsPaginates(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	for amount := Money(1); amount <= 5; amount++ {
+		assert.Nil(t, store.CreateTransaction(context.Background(), &Transaction{Reference: fmt.Sprintf("TXN-%d", amount), ToAccount: acc.ID, Amount: amount, Kind: TransactionDeposit}))
+	}
+
+	page := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/transactions%s", acc.ID, query), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	amounts := func(rec *httptest.ResponseRecorder) []Money {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		transactions := []*Transaction{}
+		decodeData(t, rec.Body, &transactions)
+		amounts := []Money{}
+		for _, t := range transactions {
+			amounts = append(amounts, t.Amount)
+		}
+		return amounts
+	}
+
+	assert.Equal(t, []Money{5, 4, 3, 2, 1}, amounts(page("")))
+	assert.Equal(t, []Money{5, 4}, amounts(page("?limit=2")))
+	assert.Equal(t, []Money{3, 2}, amounts(page("?limit=2&offset=2")))
+	assert.Equal(t, []Money{1}, amounts(page("?limit=2&offset=4")))
+	assert.Equal(t, []Money{}, amounts(page("?offset=10")))
+
+	for _, query := range []string{"?limit=0", "?limit=abc", "?offset=-1"} {
+		assert.Equal(t, http.StatusBadRequest, page(query).Code, query)
+	}
+}
+
+// TestGetTransactionsCursor tests that the history pages by cursor, newest first
+func TestGetTransactionsCursor(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	for amount := Money(1); amount <= 5; amount++ {
+		assert.Nil(t, store.CreateTransaction(context.Background(), &Transaction{Reference: fmt.Sprintf("TXN-%d", amount), ToAccount: acc.ID, Amount: amount, Kind: TransactionDeposit}))
+	}
+
+	list := func(query string) *TransactionPage {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/transactions%s", acc.ID, query), nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := new(TransactionPage)
+		decodeData(t, rec.Body, page)
+		return page
+	}
+	amounts := func(page *TransactionPage) []Money {
+		amounts := []Money{}
+		for _, t := range page.Transactions {
+			amounts = append(amounts, t.Amount)
+		}
+		return amounts
+	}
+
+	page := list("?limit=2&cursor=")
+	assert.Equal(t, []Money{5, 4}, amounts(page))
+
+	// New transactions don't shift the following pages
+	assert.Nil(t, store.CreateTransaction(context.Background(), &Transaction{Reference: "TXN-6", ToAccount: acc.ID, Amount: 6, Kind: TransactionDeposit}))
+	page = list("?limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []Money{3, 2}, amounts(page))
+	page = list("?limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []Money{1}, amounts(page))
+	assert.Empty(t, page.NextCursor)
+}
+
+// TestWithdrawDebitsBalance tests that the owner can withdraw down to the minimum balance and that it is recorded
+func TestWithdrawDebitsBalance(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 500))
+
+	withdraw := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/withdraw", acc.ID), strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := withdraw(`{"amount":200}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, Money(300), resp.Balance)
+	assert.Len(t, store.transactions, 1)
+	assert.Equal(t, TransactionWithdrawal, store.transactions[0].Kind)
+	assert.Equal(t, acc.ID, store.transactions[0].FromAccount)
+
+	// Overdrawing is rejected and leaves no record
+	rec = withdraw(`{"amount":301}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "insufficient_funds")
+	assert.Len(t, store.transactions, 1)
+
+	rec = withdraw(`{"amount":0}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "withdrawal amount must be positive")
+
+	acc, _ = store.GetAccountByID(context.Background(), acc.ID)
+	assert.Equal(t, Money(300), acc.Balance)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// requiresApproval reports whether a transfer from the sender must wait for a second approver
+func (s *APIServer) requiresApproval(sender *Account, amount int64) bool {
+	if s.config.ApprovalThreshold <= 0 || amount <= s.config.ApprovalThreshold {
+		return false
+	}
+	for _, name := range s.config.ApprovalAccountTypes {
+		if sender.Type == name {
+			return true
+		}
+	}
+	return false
+}
+
+// requestApproval holds the transfer until it is approved, or cancelled when it expires
+func (s *APIServer) requestApproval(ctx context.Context, sender *Account, req *TransferRequest) (*PendingTransfer, error) {
+	if err := s.validateTransferRequest(req); err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	pending := &PendingTransfer{
+		FromAccount: sender.ID,
+		ToAccount:   req.ToAccount,
+		Amount:      req.Amount,
+		Memo:        req.Memo,
+		PrivateNote: req.Note,
+		Status:      TransferPendingApproval,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.config.ApprovalTTL),
+	}
+	if err := s.store.CreatePendingTransfer(ctx, pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// handleApproveTransfer lets an admin other than the requester approve and execute a pending transfer
+func (s *APIServer) handleApproveTransfer(w http.ResponseWriter, r *http.Request) error {
+	approver, err := authenticatedAccount(r, s.store, s.config)
+	if err != nil || !approver.IsAdmin() {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	var receipt *TransferReceipt
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		pending, err := tx.GetPendingTransfer(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if pending.FromAccount == approver.ID {
+			return &TransferError{Code: "self_approval", Message: "transfers must be approved by another user"}
+		}
+		if pending.Status != TransferPendingApproval {
+			return &TransferError{Code: "not_pending", Message: fmt.Sprintf("transfer is %s", pending.Status)}
+		}
+		if !pending.ExpiresAt.After(s.now()) {
+			return &TransferError{Code: "approval_expired", Message: "transfer expired before it was approved"}
+		}
+
+		// Execute the transfer in the same transaction that marks it approved
+		receipt, err = s.transfer(r.Context(), tx, pending.FromAccount, &TransferRequest{
+			ToAccount: pending.ToAccount,
+			Amount:    pending.Amount,
+			Memo:      pending.Memo,
+			Note:      pending.PrivateNote,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.UpdatePendingTransfer(r.Context(), pending.ID, TransferApproved, approver.ID)
+	})
+	if err != nil {
+		return err
+	}
+	s.webhooks.Notify(receipt.changes...)
+
+	return WriteJSON(w, http.StatusOK, receipt)
+}
+
+// cancelExpiredTransfers cancels the transfers that expired unapproved every interval until ctx is done
+func (s *APIServer) cancelExpiredTransfers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cancelled, err := s.store.CancelExpiredTransfers(ctx, s.now().UTC())
+			if err != nil {
+				log.Printf("cancelling expired transfers failed: %v\n", err)
+			} else if cancelled > 0 {
+				log.Printf("cancelled %d expired transfers awaiting approval\n", cancelled)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// approveRequest builds an authenticated request approving the pending transfer
+func approveRequest(token string, id int) *http.Request {
+	req := httptest.NewRequest("POST", fmt.Sprintf("/transfer/%d/approve", id), nil)
+	req.Header.Set("x-jwt-token", token)
+	return req
+}
+
+// TestBusinessTransferNeedsSecondApprover tests that a large business transfer waits for another user's approval
+func TestBusinessTransferNeedsSecondApprover(t *testing.T) {
+	config := newTestConfig(t)
+	config.ApprovalThreshold = 500
+	server, store := newTestServer(config)
+	sender, senderToken := newTestAccount(t, store, RoleAdmin)
+	store.accounts[sender.ID].Type = "business"
+	recipient, recipientToken := newTestAccount(t, store, RoleUser)
+	_, approverToken := newTestAccount(t, store, RoleAdmin)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	// Small transfers go straight through
+	rec := serve(server, transferRequest(senderToken, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Large ones are held
+	rec = serve(server, transferRequest(senderToken, recipient.Number, 600))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	pending := new(PendingTransfer)
+	decodeData(t, rec.Body, pending)
+	assert.Equal(t, TransferPendingApproval, pending.Status)
+
+	// The sender can't approve their own transfer, and non-admins can't approve at all
+	rec = serve(server, approveRequest(senderToken, pending.ID))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "self_approval")
+	rec = serve(server, approveRequest(recipientToken, pending.ID))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	acc, _ := store.GetAccountByID(context.Background(), sender.ID)
+	assert.Equal(t, Money(900), acc.Balance)
+
+	// A second admin approves and executes it, once
+	rec = serve(server, approveRequest(approverToken, pending.ID))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	acc, _ = store.GetAccountByID(context.Background(), sender.ID)
+	assert.Equal(t, Money(300), acc.Balance)
+
+	rec = serve(server, approveRequest(approverToken, pending.ID))
+	assert.Contains(t, rec.Body.String(), "not_pending")
+}
+
+// TestExpiredTransferCancelled tests that a transfer left unapproved past its expiry can't be approved
+func TestExpiredTransferCancelled(t *testing.T) {
+	config := newTestConfig(t)
+	config.ApprovalThreshold = 500
+	server, store := newTestServer(config)
+	sender, senderToken := newTestAccount(t, store, RoleUser)
+	store.accounts[sender.ID].Type = "business"
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	_, approverToken := newTestAccount(t, store, RoleAdmin)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	rec := serve(server, transferRequest(senderToken, recipient.Number, 600))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// The transfer expires and is swept
+	expired := time.Now().Add(config.ApprovalTTL + time.Minute)
+	cancelled, err := store.CancelExpiredTransfers(context.Background(), expired)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), cancelled)
+
+	rec = serve(server, approveRequest(approverToken, 1))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "transfer is cancelled")
+}
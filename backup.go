@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is used to name export files so they sort chronologically
+const backupTimeFormat = "20060102T150405Z"
+
+// backupBatchSize is the number of records read from the store at once while exporting
+const backupBatchSize = 1000
+
+// Kinds of the records of an export
+const (
+	backupRecordAccount     = "account"
+	backupRecordTransaction = "transaction"
+)
+
+// BackupExporter periodically exports the accounts and transactions as gzip compressed JSON lines
+type BackupExporter struct {
+	store  Storage
+	dir    string // Directory the exports are written to
+	retain int    // Number of exports kept, older ones are pruned
+}
+
+// BackupManifest describes a single export so it can be verified before a restore
+type BackupManifest struct {
+	File         string    `json:"file"`         // Name of the export file
+	CreatedAt    time.Time `json:"createdAt"`    // Time the export was taken
+	Accounts     int       `json:"accounts"`     // Number of exported accounts, including deleted ones
+	Transactions int       `json:"transactions"` // Number of exported transactions
+	SHA256       string    `json:"sha256"`       // Checksum of the compressed export file
+}
+
+// backupAccount is the exported form of an account, including the password and PIN hashes needed to restore it
+type backupAccount struct {
+	Record string `json:"record"`
+	*Account
+	EncryptedPassword string `json:"encryptedPassword"`
+	EncryptedPin      string `json:"encryptedPin,omitempty"`
+}
+
+// backupTransaction is the exported form of a transaction, including the hashes chaining it to the previous ones
+type backupTransaction struct {
+	Record string `json:"record"`
+	*Transaction
+	FromPrevHash string `json:"fromPrevHash,omitempty"`
+	ToPrevHash   string `json:"toPrevHash,omitempty"`
+}
+
+// NewBackupExporter creates an exporter writing to dir and keeping the latest retain exports
+func NewBackupExporter(store Storage, dir string, retain int) *BackupExporter {
+	return &BackupExporter{
+		store:  store,
+		dir:    dir,
+		retain: retain,
+	}
+}
+
+// Run exports the accounts and transactions every interval until ctx is done
+func (b *BackupExporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			manifest, err := b.ExportToDir(ctx, now)
+			if err != nil {
+				log.Println("backup export failed: ", err)
+				continue
+			}
+			log.Printf("backup export %s written with %d accounts and %d transactions\n", manifest.File, manifest.Accounts, manifest.Transactions)
+		}
+	}
+}
+
+// Export writes the accounts, deleted ones included, followed by the transactions to w as gzip compressed
+// JSON lines and returns the export's manifest; each line names its kind in the record field
+// Both are read in batches by ID, so the export never holds the whole database in memory
+func (b *BackupExporter) Export(ctx context.Context, w io.Writer) (*BackupManifest, error) {
+	// Checksum the compressed bytes as they are written
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(w, hash))
+	enc := json.NewEncoder(gz)
+	manifest := new(BackupManifest)
+
+	filter := AccountFilter{IncludeDeleted: true}
+	for cursor := 0; ; {
+		accounts, err := b.store.GetAccountsAfter(ctx, cursor, backupBatchSize, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, acc := range accounts {
+			record := backupAccount{Record: backupRecordAccount, Account: acc, EncryptedPassword: acc.EncryptedPassword, EncryptedPin: acc.EncryptedPin}
+			if err := enc.Encode(record); err != nil {
+				return nil, err
+			}
+		}
+		manifest.Accounts += len(accounts)
+		if len(accounts) < backupBatchSize {
+			break
+		}
+		cursor = accounts[len(accounts)-1].ID
+	}
+
+	for cursor := 0; ; {
+		transactions, err := b.store.GetTransactionsAfter(ctx, cursor, backupBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transactions {
+			record := backupTransaction{Record: backupRecordTransaction, Transaction: t, FromPrevHash: t.FromPrevHash, ToPrevHash: t.ToPrevHash}
+			if err := enc.Encode(record); err != nil {
+				return nil, err
+			}
+		}
+		manifest.Transactions += len(transactions)
+		if len(transactions) < backupBatchSize {
+			break
+		}
+		cursor = transactions[len(transactions)-1].ID
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return manifest, nil
+}
+
+// ExportToDir writes an export and its manifest to the backup directory, then prunes old exports
+func (b *BackupExporter) ExportToDir(ctx context.Context, now time.Time) (*BackupManifest, error) {
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	// Write the export to a temporary file first so a failed run leaves no partial export behind
+	name := "accounts-" + now.UTC().Format(backupTimeFormat)
+	tmp, err := os.CreateTemp(b.dir, name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := b.Export(ctx, tmp)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(b.dir, name+".jsonl.gz")); err != nil {
+		return nil, err
+	}
+
+	// Write the manifest next to the export
+	manifest.File = name + ".jsonl.gz"
+	manifest.CreatedAt = now.UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(b.dir, name+".manifest.json"), data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return manifest, b.prune()
+}
+
+// prune removes all but the latest retained exports together with their manifests
+func (b *BackupExporter) prune() error {
+	exports, err := filepath.Glob(filepath.Join(b.dir, "accounts-*.jsonl.gz"))
+	if err != nil {
+		return err
+	}
+	if len(exports) <= b.retain {
+		return nil
+	}
+
+	// Export names sort chronologically
+	sort.Strings(exports)
+	for _, export := range exports[:len(exports)-b.retain] {
+		if err := os.Remove(export); err != nil {
+			return err
+		}
+		manifest := strings.TrimSuffix(export, ".jsonl.gz") + ".manifest.json"
+		if err := os.Remove(manifest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestBackupExportChecksummed tests that an export run produces a valid, checksummed file of the accounts and transactions
+func TestBackupExportChecksummed(t *testing.T) {
+	store := newMemStore()
+	alice, _ := newTestAccount(t, store, RoleUser)
+	bob, _ := newTestAccount(t, store, RoleUser)
+	pin, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	assert.Nil(t, store.UpdatePin(context.Background(), alice.ID, string(pin)))
+	deposit := &Transaction{Reference: "TXN-1", ToAccount: alice.ID, Amount: 500, Kind: TransactionDeposit, ToPrevHash: "abc"}
+	assert.Nil(t, store.CreateTransaction(context.Background(), deposit))
+	assert.Nil(t, store.DeleteAccount(context.Background(), bob.ID))
+
+	dir := t.TempDir()
+	exporter := NewBackupExporter(store, dir, 2)
+	now := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	manifest, err := exporter.ExportToDir(context.Background(), now)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, manifest.Accounts)
+	assert.Equal(t, 1, manifest.Transactions)
+
+	// The manifest on disk matches the returned one
+	data, err := os.ReadFile(filepath.Join(dir, "accounts-20260301T020000Z.manifest.json"))
+	assert.Nil(t, err)
+	stored := new(BackupManifest)
+	assert.Nil(t, json.Unmarshal(data, stored))
+	assert.Equal(t, manifest.SHA256, stored.SHA256)
+
+	// The checksum matches the export file
+	export, err := os.ReadFile(filepath.Join(dir, manifest.File))
+	assert.Nil(t, err)
+	sum := sha256.Sum256(export)
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest.SHA256)
+
+	// The export holds the accounts, including deleted ones and their password and PIN hashes, then the transactions
+	f, err := os.Open(filepath.Join(dir, manifest.File))
+	assert.Nil(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+
+	numbers := []int64{}
+	references := []string{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		record := map[string]any{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		switch record["record"] {
+		case backupRecordAccount:
+			// The hashes restore an account that accepts the same password and PIN
+			restored := backupAccount{Account: new(Account)}
+			assert.Nil(t, json.Unmarshal(scanner.Bytes(), &restored))
+			restored.Account.EncryptedPassword = restored.EncryptedPassword
+			restored.Account.EncryptedPin = restored.EncryptedPin
+			assert.True(t, restored.ValidPassword("hunter88888"))
+			assert.Equal(t, restored.Number == alice.Number, restored.ValidPin("1234"))
+			numbers = append(numbers, restored.Number)
+		case backupRecordTransaction:
+			assert.Equal(t, "abc", record["toPrevHash"])
+			references = append(references, record["reference"].(string))
+		default:
+			t.Errorf("unexpected record %v", record)
+		}
+	}
+	assert.Equal(t, []int64{alice.Number, bob.Number}, numbers)
+	assert.Equal(t, []string{"TXN-1"}, references)
+
+	// Older exports are pruned beyond the retention
+	for i := 1; i <= 2; i++ {
+		_, err := exporter.ExportToDir(context.Background(), now.Add(time.Duration(i)*time.Hour))
+		assert.Nil(t, err)
+	}
+	exports, err := filepath.Glob(filepath.Join(dir, "accounts-*"))
+	assert.Nil(t, err)
+	assert.Len(t, exports, 4)
+	assert.NotContains(t, exports, filepath.Join(dir, manifest.File))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// errRequestTooLarge reports a request body above the configured size limit
+var errRequestTooLarge = errors.New("request body too large")
+
+// limitedBody is a request body cut off by http.MaxBytesReader, reporting the cut as errRequestTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	// MaxBytesReader only fails after handing out the whole allowance
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		err = errRequestTooLarge
+	}
+	return n, err
+}
+
+// bodyLimitMiddleware caps the size of request bodies, so that a huge body can't exhaust the memory
+// of a handler decoding it
+func bodyLimitMiddleware(limit int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
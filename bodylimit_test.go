@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBodyLimit tests that request bodies above the configured limit are rejected with 413
+func TestBodyLimit(t *testing.T) {
+	config := newTestConfig(t)
+	config.MaxBodyBytes = 128
+	server, _ := newTestServer(config)
+
+	body := `{"firstName":"a","lastName":"b","password":"hunter88888"}`
+	rec := serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body = `{"firstName":"` + strings.Repeat("a", 200) + `","lastName":"b","password":"hunter88888"}`
+	rec = serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Contains(t, rec.Body.String(), "request body too large")
+}
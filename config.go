@@ -0,0 +1,600 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime configuration of the application
+type Config struct {
+	ListenAddr           string                 // Address the HTTP server listens on
+	JWTSecret            string                 // Key signing and verifying the JWT tokens
+	DatabaseURL          string                 // Connection string of the Postgres database
+	Environment          string                 // Name of the deployment environment, e.g. development or production
+	Deprecations         []Deprecation          // Endpoints and response fields scheduled for removal
+	BatchGetMaxIDs       int                    // Maximum number of ids accepted by a single batch account lookup
+	TransactionRefPrefix string                 // Prefix of the human-shareable transaction references
+	AccountTypes         map[string]AccountType // Rules of every supported account type, keyed by name
+	DefaultAccountType   string                 // Type of accounts created without an explicit type
+	AccountNumbers       AccountNumberPolicy    // Format of generated and accepted account numbers
+	BackupDir            string                 // Directory of the periodic account exports, disabled when empty
+	BackupInterval       time.Duration          // Time between two account exports
+	BackupRetain         int                    // Number of account exports kept
+	PinThreshold         int64                  // Transfers above this amount require the transaction PIN, 0 disables it
+	PinMaxAttempts       int                    // Consecutive wrong PINs before transfers are locked
+	PinLockout           time.Duration          // How long transfers stay locked after too many wrong PINs
+	DefaultCurrency      string                 // ISO 4217 currency of account balances
+	DisplayLocale        string                 // Locale used to format amounts for display, e.g. en-US
+	RequireRequestID     bool                   // Reject requests without an X-Request-ID header instead of generating one
+	AdminAllowCIDRs      []*net.IPNet           // Networks allowed to reach /admin routes, all when empty
+	AdminDenyCIDRs       []*net.IPNet           // Networks never allowed to reach /admin routes
+	TrustedProxies       []*net.IPNet           // Proxies whose X-Forwarded-For header is trusted
+	RequireActivation    bool                   // New accounts must confirm their email address before transferring
+	ActivationTokenTTL   time.Duration          // How long an emailed activation token stays valid
+	PasswordResetTTL     time.Duration          // How long an emailed password reset token stays valid
+	DestinationDailyCap  int64                  // Maximum total sent to a single recipient per UTC day, 0 disables it
+	DailyTransferLimit   int64                  // Maximum total an account sends per UTC day, 0 disables it
+	DegradedMode         bool                   // Keep serving reads, rejecting writes with 503, while the database is read-only
+	HealthCheckInterval  time.Duration          // Time between two database health checks
+	JWTLeeway            time.Duration          // Clock skew tolerated when checking the exp, nbf and iat token claims
+	BootstrapAdmin       *BootstrapAdmin        // Admin account created on startup when none exists, nil to disable
+	MemoMaxLength        int                    // Maximum length of transfer memos and private notes
+	ResponseTiming       bool                   // Report handling times in the Server-Timing and X-Response-Time-Ms headers
+	TransferCooldown     time.Duration          // Minimum time between two transfers of an account, 0 disables it
+	ApprovalAccountTypes []string               // Account types whose large transfers need a second approver
+	ApprovalThreshold    int64                  // Transfers above this amount from those types need approval, 0 disables it
+	ApprovalTTL          time.Duration          // How long a transfer waits for approval before it is cancelled
+	AmountMaxDecimals    int                    // Decimal places accepted in decimal amounts, -1 for the currency scale
+	LedgerHashChain      bool                   // Chain every transaction to the previous ones of its accounts by hash
+	TokenTTL             time.Duration          // Lifetime of the issued JWT tokens
+	TokenMaxTTL          time.Duration          // Hard cap on the lifetime of any issued JWT token
+	LoginDedup           bool                   // Share one password check between concurrent identical logins
+	ExportInterval       time.Duration          // Minimum time between two data exports of an account
+	LoginPenaltyBase     time.Duration          // Penalty of a first failed login, doubling with every further failure, 0 disables it
+	LoginPenaltyMax      time.Duration          // Largest penalty of a failed login
+	LoginPenaltyWindow   time.Duration          // Failed logins older than this no longer count towards the penalty
+	LoginPenaltyMode     string                 // How the penalty is enforced, one of PenaltyDelay or PenaltyReject
+	AnomalyFactor        int                    // Transfers this many times above the sender's average are flagged, 0 disables it
+	AnomalyMinHistory    int                    // Transfers an account must have sent before its average is trusted
+	AnomalyHold          bool                   // Hold flagged transfers for approval instead of executing them
+	ShutdownTimeout      time.Duration          // How long in-flight requests may take to finish on shutdown
+	QueryTimeout         time.Duration          // Longest a single storage call may take, 0 for no limit
+	DBMaxOpenConns       int                    // Most database connections open at once, 0 for no limit
+	DBMaxIdleConns       int                    // Most idle database connections kept for reuse
+	DBConnMaxLifetime    time.Duration          // Longest a database connection is reused, 0 for no limit
+	MaxBodyBytes         int64                  // Largest request body accepted, 0 for no limit
+	TLSCertFile          string                 // PEM certificate served over HTTPS, plain HTTP when empty
+	TLSKeyFile           string                 // PEM private key of the TLS certificate
+	TransferMinAmount    int64                  // Smallest amount a single transfer may move
+	TransferMaxAmount    int64                  // Largest amount a single transfer may move, 0 for no limit
+	LogLevel             string                 // Lowest level logged, one of LogLevelDebug or LogLevelInfo
+	CORSAllowedOrigin    string                 // Origin browser clients may call the API from, "*" for any
+	LoginMaxAttempts     int                    // Consecutive failed logins locking an account number, 0 disables the lockout
+	LoginLockout         time.Duration          // How long logins stay locked after too many failures
+	InterestInterval     time.Duration          // Time between two interest accruals, 0 disables them
+	WebhookAttempts      int                    // Deliveries of a webhook event tried before it is dropped
+	WebhookBackoff       time.Duration          // Wait before the first webhook retry, doubling with every further one
+	WebhookAllowPrivate  bool                   // Whether webhooks may target loopback, private and link-local addresses
+	RevocationPurge      time.Duration          // Time between two purges of the revocations of expired tokens
+}
+
+// BootstrapAdmin holds the credentials of the admin account created on a fresh deployment
+type BootstrapAdmin struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Password  string
+	Number    int64 // Account number, generated when 0
+}
+
+// minJWTSecretLength is the shortest accepted JWT signing key, matching the 256-bit output of HS256
+const minJWTSecretLength = 32
+
+// defaultDatabaseURL is the connection string of the local development database
+const defaultDatabaseURL = "user=postgres dbname=postgres password=gobank sslmode=disable"
+
+// LoadConfig builds the configuration from environment variables
+func LoadConfig() (*Config, error) {
+	// Tokens signed with an empty or short key could be forged by anyone
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, fmt.Errorf("JWT_SECRET must be set")
+	}
+	if len(jwtSecret) < minJWTSecretLength {
+		return nil, fmt.Errorf("JWT_SECRET must be at least %d characters long", minJWTSecretLength)
+	}
+
+	// Parse the deprecated endpoints and fields, e.g. "/account/{id}#number=2027-01-01"
+	deprecations, err := parseDeprecations(os.Getenv("DEPRECATIONS"))
+	if err != nil {
+		return nil, err
+	}
+
+	batchGetMaxIDs, err := envInt("BATCH_GET_MAX_IDS", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the built-in account types and apply overrides, e.g. "business:50000:0"
+	accountTypes := defaultAccountTypes()
+	if err := parseAccountTypes(os.Getenv("ACCOUNT_TYPES"), accountTypes); err != nil {
+		return nil, err
+	}
+	defaultAccountType := envString("DEFAULT_ACCOUNT_TYPE", "checking")
+	if _, ok := accountTypes[defaultAccountType]; !ok {
+		return nil, fmt.Errorf("default account type %q is not configured", defaultAccountType)
+	}
+
+	accountNumberDigits, err := envInt("ACCOUNT_NUMBER_DIGITS", 6)
+	if err != nil {
+		return nil, err
+	}
+	if accountNumberDigits < 2 || accountNumberDigits > 18 {
+		return nil, fmt.Errorf("ACCOUNT_NUMBER_DIGITS must be between 2 and 18")
+	}
+	luhn, err := envBool("ACCOUNT_NUMBER_LUHN", false)
+	if err != nil {
+		return nil, err
+	}
+	// Exact numbers and ranges never assigned, e.g. "100000-100999,123456"
+	accountNumberBlacklist, err := parseNumberRanges(os.Getenv("ACCOUNT_NUMBER_BLACKLIST"))
+	if err != nil {
+		return nil, err
+	}
+	accountNumbers := AccountNumberPolicy{Digits: accountNumberDigits, Luhn: luhn, Blacklist: accountNumberBlacklist}
+	if accountNumbers.exhausted() {
+		return nil, fmt.Errorf("ACCOUNT_NUMBER_BLACKLIST leaves no account numbers to assign")
+	}
+
+	backupInterval, err := envDuration("BACKUP_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if backupInterval <= 0 {
+		return nil, fmt.Errorf("BACKUP_INTERVAL must be positive")
+	}
+	// The export just written is always kept
+	backupRetain, err := envInt("BACKUP_RETAIN", 7)
+	if err != nil {
+		return nil, err
+	}
+	if backupRetain < 1 {
+		return nil, fmt.Errorf("BACKUP_RETAIN must be at least 1")
+	}
+
+	pinThreshold, err := envInt("PIN_THRESHOLD", 100000)
+	if err != nil {
+		return nil, err
+	}
+	pinMaxAttempts, err := envInt("PIN_MAX_ATTEMPTS", 3)
+	if err != nil {
+		return nil, err
+	}
+	pinLockout, err := envDuration("PIN_LOCKOUT", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultCurrency := envString("DEFAULT_CURRENCY", "USD")
+	if _, ok := currencies[defaultCurrency]; !ok {
+		return nil, fmt.Errorf("unsupported DEFAULT_CURRENCY %q", defaultCurrency)
+	}
+	displayLocale := envString("DISPLAY_LOCALE", "en-US")
+	if _, ok := locales[displayLocale]; !ok {
+		return nil, fmt.Errorf("unsupported DISPLAY_LOCALE %q", displayLocale)
+	}
+
+	requireRequestID, err := envBool("REQUIRE_REQUEST_ID", false)
+	if err != nil {
+		return nil, err
+	}
+
+	adminAllowCIDRs, err := parseCIDRs(os.Getenv("ADMIN_ALLOW_CIDRS"))
+	if err != nil {
+		return nil, err
+	}
+	adminDenyCIDRs, err := parseCIDRs(os.Getenv("ADMIN_DENY_CIDRS"))
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
+	requireActivation, err := envBool("REQUIRE_ACTIVATION", false)
+	if err != nil {
+		return nil, err
+	}
+	activationTokenTTL, err := envDuration("ACTIVATION_TOKEN_TTL", 48*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	passwordResetTTL, err := envDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	destinationDailyCap, err := envInt("DESTINATION_DAILY_CAP", 0)
+	if err != nil {
+		return nil, err
+	}
+	dailyTransferLimit, err := envInt("DAILY_TRANSFER_LIMIT", 0)
+	if err != nil {
+		return nil, err
+	}
+	degradedMode, err := envBool("DEGRADED_MODE", false)
+	if err != nil {
+		return nil, err
+	}
+	healthCheckInterval, err := envDuration("HEALTH_CHECK_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if healthCheckInterval <= 0 {
+		return nil, fmt.Errorf("HEALTH_CHECK_INTERVAL must be positive")
+	}
+	jwtLeeway, err := envDuration("JWT_LEEWAY", 0)
+	if err != nil {
+		return nil, err
+	}
+	// The bootstrap admin is enabled by setting its password
+	var bootstrapAdmin *BootstrapAdmin
+	if password := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"); password != "" {
+		number, err := envInt("BOOTSTRAP_ADMIN_NUMBER", 0)
+		if err != nil {
+			return nil, err
+		}
+		bootstrapAdmin = &BootstrapAdmin{
+			FirstName: envString("BOOTSTRAP_ADMIN_FIRST_NAME", "admin"),
+			LastName:  envString("BOOTSTRAP_ADMIN_LAST_NAME", "admin"),
+			Email:     os.Getenv("BOOTSTRAP_ADMIN_EMAIL"),
+			Password:  password,
+			Number:    int64(number),
+		}
+	}
+	memoMaxLength, err := envInt("MEMO_MAX_LENGTH", 140)
+	if err != nil {
+		return nil, err
+	}
+	if memoMaxLength < 0 || memoMaxLength > 500 {
+		return nil, fmt.Errorf("MEMO_MAX_LENGTH must be between 0 and 500")
+	}
+	responseTiming, err := envBool("RESPONSE_TIMING", false)
+	if err != nil {
+		return nil, err
+	}
+	transferCooldown, err := envDuration("TRANSFER_COOLDOWN", 0)
+	if err != nil {
+		return nil, err
+	}
+	approvalAccountTypes := []string{}
+	for _, name := range strings.Split(envString("APPROVAL_ACCOUNT_TYPES", "business"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			approvalAccountTypes = append(approvalAccountTypes, name)
+		}
+	}
+	approvalThreshold, err := envInt("APPROVAL_THRESHOLD", 0)
+	if err != nil {
+		return nil, err
+	}
+	approvalTTL, err := envDuration("APPROVAL_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	amountMaxDecimals, err := envInt("AMOUNT_MAX_DECIMALS", -1)
+	if err != nil {
+		return nil, err
+	}
+	ledgerHashChain, err := envBool("LEDGER_HASH_CHAIN", true)
+	if err != nil {
+		return nil, err
+	}
+	tokenTTL, err := envDuration("TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	tokenMaxTTL, err := envDuration("TOKEN_MAX_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if tokenMaxTTL <= 0 {
+		return nil, fmt.Errorf("TOKEN_MAX_TTL must be positive")
+	}
+	loginDedup, err := envBool("LOGIN_DEDUP", true)
+	if err != nil {
+		return nil, err
+	}
+	exportInterval, err := envDuration("EXPORT_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	loginPenaltyBase, err := envDuration("LOGIN_PENALTY_BASE", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	loginPenaltyMax, err := envDuration("LOGIN_PENALTY_MAX", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	loginPenaltyWindow, err := envDuration("LOGIN_PENALTY_WINDOW", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	loginPenaltyMode := envString("LOGIN_PENALTY_MODE", PenaltyDelay)
+	if loginPenaltyMode != PenaltyDelay && loginPenaltyMode != PenaltyReject {
+		return nil, fmt.Errorf("LOGIN_PENALTY_MODE must be %s or %s", PenaltyDelay, PenaltyReject)
+	}
+	anomalyFactor, err := envInt("ANOMALY_FACTOR", 10)
+	if err != nil {
+		return nil, err
+	}
+	anomalyMinHistory, err := envInt("ANOMALY_MIN_HISTORY", 5)
+	if err != nil {
+		return nil, err
+	}
+	anomalyHold, err := envBool("ANOMALY_HOLD", false)
+	if err != nil {
+		return nil, err
+	}
+	shutdownTimeout, err := envDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	loginMaxAttempts, err := envInt("LOGIN_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	loginLockout, err := envDuration("LOGIN_LOCKOUT", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	interestInterval, err := envDuration("INTEREST_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if interestInterval < 0 {
+		return nil, fmt.Errorf("INTEREST_INTERVAL must not be negative")
+	}
+	webhookAttempts, err := envInt("WEBHOOK_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if webhookAttempts < 1 {
+		return nil, fmt.Errorf("WEBHOOK_ATTEMPTS must be at least 1")
+	}
+	webhookBackoff, err := envDuration("WEBHOOK_BACKOFF", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// Only for development, where the receivers run next to the server
+	webhookAllowPrivate, err := envBool("WEBHOOK_ALLOW_PRIVATE", false)
+	if err != nil {
+		return nil, err
+	}
+	revocationPurgeInterval, err := envDuration("REVOCATION_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if revocationPurgeInterval <= 0 {
+		return nil, fmt.Errorf("REVOCATION_PURGE_INTERVAL must be positive")
+	}
+	queryTimeout, err := envDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	dbMaxOpenConns, err := envInt("DB_MAX_OPEN_CONNS", 25)
+	if err != nil {
+		return nil, err
+	}
+	dbMaxIdleConns, err := envInt("DB_MAX_IDLE_CONNS", 10)
+	if err != nil {
+		return nil, err
+	}
+	dbConnMaxLifetime, err := envDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	maxBodyBytes, err := envInt("MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	tlsCertFile, tlsKeyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	transferMinAmount, err := envInt("TRANSFER_MIN_AMOUNT", 1)
+	if err != nil {
+		return nil, err
+	}
+	transferMaxAmount, err := envInt("TRANSFER_MAX_AMOUNT", 1000000)
+	if err != nil {
+		return nil, err
+	}
+	if transferMaxAmount > 0 && transferMaxAmount < transferMinAmount {
+		return nil, fmt.Errorf("TRANSFER_MAX_AMOUNT must not be below TRANSFER_MIN_AMOUNT")
+	}
+	logLevel := envString("LOG_LEVEL", LogLevelInfo)
+	if logLevel != LogLevelDebug && logLevel != LogLevelInfo {
+		return nil, fmt.Errorf("LOG_LEVEL must be %s or %s", LogLevelDebug, LogLevelInfo)
+	}
+
+	return &Config{
+		ListenAddr:           envString("LISTEN_ADDR", ":3000"),
+		JWTSecret:            jwtSecret,
+		DatabaseURL:          envString("DB_URL", defaultDatabaseURL),
+		Environment:          envString("APP_ENV", "development"),
+		Deprecations:         deprecations,
+		BatchGetMaxIDs:       batchGetMaxIDs,
+		TransactionRefPrefix: envString("TRANSACTION_REF_PREFIX", "TXN"),
+		AccountTypes:         accountTypes,
+		DefaultAccountType:   defaultAccountType,
+		AccountNumbers:       accountNumbers,
+		BackupDir:            os.Getenv("BACKUP_DIR"),
+		BackupInterval:       backupInterval,
+		BackupRetain:         backupRetain,
+		PinThreshold:         int64(pinThreshold),
+		PinMaxAttempts:       pinMaxAttempts,
+		PinLockout:           pinLockout,
+		DefaultCurrency:      defaultCurrency,
+		DisplayLocale:        displayLocale,
+		RequireRequestID:     requireRequestID,
+		AdminAllowCIDRs:      adminAllowCIDRs,
+		AdminDenyCIDRs:       adminDenyCIDRs,
+		TrustedProxies:       trustedProxies,
+		RequireActivation:    requireActivation,
+		ActivationTokenTTL:   activationTokenTTL,
+		PasswordResetTTL:     passwordResetTTL,
+		DestinationDailyCap:  int64(destinationDailyCap),
+		DailyTransferLimit:   int64(dailyTransferLimit),
+		DegradedMode:         degradedMode,
+		HealthCheckInterval:  healthCheckInterval,
+		JWTLeeway:            jwtLeeway,
+		BootstrapAdmin:       bootstrapAdmin,
+		MemoMaxLength:        memoMaxLength,
+		ResponseTiming:       responseTiming,
+		TransferCooldown:     transferCooldown,
+		ApprovalAccountTypes: approvalAccountTypes,
+		ApprovalThreshold:    int64(approvalThreshold),
+		ApprovalTTL:          approvalTTL,
+		AmountMaxDecimals:    amountMaxDecimals,
+		LedgerHashChain:      ledgerHashChain,
+		TokenTTL:             tokenTTL,
+		TokenMaxTTL:          tokenMaxTTL,
+		LoginDedup:           loginDedup,
+		ExportInterval:       exportInterval,
+		LoginPenaltyBase:     loginPenaltyBase,
+		LoginPenaltyMax:      loginPenaltyMax,
+		LoginPenaltyWindow:   loginPenaltyWindow,
+		LoginPenaltyMode:     loginPenaltyMode,
+		AnomalyFactor:        anomalyFactor,
+		AnomalyMinHistory:    anomalyMinHistory,
+		AnomalyHold:          anomalyHold,
+		ShutdownTimeout:      shutdownTimeout,
+		QueryTimeout:         queryTimeout,
+		DBMaxOpenConns:       dbMaxOpenConns,
+		DBMaxIdleConns:       dbMaxIdleConns,
+		DBConnMaxLifetime:    dbConnMaxLifetime,
+		MaxBodyBytes:         int64(maxBodyBytes),
+		TLSCertFile:          tlsCertFile,
+		TLSKeyFile:           tlsKeyFile,
+		TransferMinAmount:    int64(transferMinAmount),
+		TransferMaxAmount:    int64(transferMaxAmount),
+		LogLevel:             logLevel,
+		CORSAllowedOrigin:    envString("CORS_ALLOWED_ORIGIN", "*"),
+		LoginMaxAttempts:     loginMaxAttempts,
+		LoginLockout:         loginLockout,
+		InterestInterval:     interestInterval,
+		WebhookAttempts:      webhookAttempts,
+		WebhookBackoff:       webhookBackoff,
+		WebhookAllowPrivate:  webhookAllowPrivate,
+		RevocationPurge:      revocationPurgeInterval,
+	}, nil
+}
+
+// AccountType looks up the rules of the named account type, falling back to the default type
+func (c *Config) AccountType(name string) (AccountType, error) {
+	if name == "" {
+		name = c.DefaultAccountType
+	}
+
+	accType, ok := c.AccountTypes[name]
+	if !ok {
+		return AccountType{}, validationErrorf("unknown account type %s", name)
+	}
+	return accType, nil
+}
+
+// defaultAccountTypes returns the built-in account types
+func defaultAccountTypes() map[string]AccountType {
+	return map[string]AccountType{
+		"checking": {Name: "checking", MinBalance: 0, InterestRate: 0},
+		"savings":  {Name: "savings", MinBalance: 10000, InterestRate: 0.02},
+		"business": {Name: "business", MinBalance: 0, InterestRate: 0},
+	}
+}
+
+// parseAccountTypes adds or overrides account types from a comma separated list of
+// "name:minBalance:interestRate" entries
+func parseAccountTypes(spec string, accountTypes map[string]AccountType) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return fmt.Errorf("invalid account type %q, expected name:minBalance:interestRate", entry)
+		}
+		minBalance, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid minimum balance in account type %q", entry)
+		}
+		interestRate, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return fmt.Errorf("invalid interest rate in account type %q", entry)
+		}
+
+		accountTypes[parts[0]] = AccountType{
+			Name:         parts[0],
+			MinBalance:   Money(minBalance),
+			InterestRate: interestRate,
+		}
+	}
+
+	return nil
+}
+
+// envString reads a string environment variable, falling back to def when it is unset
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt reads an integer environment variable, falling back to def when it is unset
+func envInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be an integer", key, value)
+	}
+	return n, nil
+}
+
+// envBool reads a boolean environment variable, falling back to def when it is unset
+func envBool(key string, def bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be a boolean", key, value)
+	}
+	return b, nil
+}
+
+// envDuration reads a duration environment variable such as "15m", falling back to def when it is unset
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be a duration", key, value)
+	}
+	return d, nil
+}
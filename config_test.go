@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadConfigFromEnvironment tests that the server settings come from the environment and a JWT secret is required
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("LISTEN_ADDR", ":8080")
+	t.Setenv("DB_URL", "postgres://bank@db/bank")
+
+	config, err := LoadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, ":8080", config.ListenAddr)
+	assert.Equal(t, testJWTSecret, config.JWTSecret)
+	assert.Equal(t, "postgres://bank@db/bank", config.DatabaseURL)
+
+	t.Setenv("JWT_SECRET", "")
+	_, err = LoadConfig()
+	assert.EqualError(t, err, "JWT_SECRET must be set")
+}
+
+// TestLoadConfigRejectsShortJWTSecret tests that a guessable signing key stops the startup
+func TestLoadConfigRejectsShortJWTSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	_, err := LoadConfig()
+	assert.EqualError(t, err, "JWT_SECRET must be at least 32 characters long")
+
+	t.Setenv("JWT_SECRET", strings.Repeat("k", minJWTSecretLength))
+	_, err = LoadConfig()
+	assert.Nil(t, err)
+}
+
+// TestLoadConfigConnectionPool tests that the database connection pool settings come from the environment
+func TestLoadConfigConnectionPool(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	config, err := LoadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 25, config.DBMaxOpenConns)
+	assert.Equal(t, 10, config.DBMaxIdleConns)
+	assert.Equal(t, 30*time.Minute, config.DBConnMaxLifetime)
+
+	t.Setenv("DB_MAX_OPEN_CONNS", "50")
+	t.Setenv("DB_MAX_IDLE_CONNS", "5")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+	config, err = LoadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 50, config.DBMaxOpenConns)
+	assert.Equal(t, 5, config.DBMaxIdleConns)
+	assert.Equal(t, time.Hour, config.DBConnMaxLifetime)
+
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	_, err = LoadConfig()
+	assert.NotNil(t, err)
+}
+
+// TestLoadConfigTLS tests that the TLS certificate and key are configured together
+func TestLoadConfigTLS(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("TLS_CERT_FILE", "/etc/gobank/cert.pem")
+	_, err := LoadConfig()
+	assert.EqualError(t, err, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+
+	t.Setenv("TLS_KEY_FILE", "/etc/gobank/key.pem")
+	config, err := LoadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "/etc/gobank/cert.pem", config.TLSCertFile)
+	assert.Equal(t, "/etc/gobank/key.pem", config.TLSKeyFile)
+}
+
+// TestLoadConfigBackup tests that backup settings which would break the exporter are rejected
+func TestLoadConfigBackup(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	for env, value := range map[string]string{"BACKUP_INTERVAL": "0s", "BACKUP_RETAIN": "0"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			_, err := LoadConfig()
+			assert.NotNil(t, err)
+		})
+	}
+
+	t.Setenv("BACKUP_RETAIN", "-1")
+	_, err := LoadConfig()
+	assert.EqualError(t, err, "BACKUP_RETAIN must be at least 1")
+}
+
+// TestLoadConfigHealthCheckInterval tests that the always running health monitor needs a positive interval
+func TestLoadConfigHealthCheckInterval(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	for _, value := range []string{"0s", "-5s"} {
+		t.Setenv("HEALTH_CHECK_INTERVAL", value)
+		_, err := LoadConfig()
+		assert.EqualError(t, err, "HEALTH_CHECK_INTERVAL must be positive")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// corsAllowedMethods and corsAllowedHeaders are what browser clients may use in cross-origin requests
+var (
+	corsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsAllowedHeaders = []string{"Content-Type", "x-jwt-token", requestIDHeader}
+)
+
+// corsMiddleware lets browser clients served from the allowed origin call the API
+// Preflight requests are answered right away with 204
+func corsMiddleware(allowedOrigin string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+			if allowedOrigin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
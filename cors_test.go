@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCORS tests that responses allow the configured origin and preflight requests are answered with 204
+func TestCORS(t *testing.T) {
+	config := newTestConfig(t)
+	config.CORSAllowedOrigin = "https://bank.example.com"
+	server, _ := newTestServer(config)
+
+	req := httptest.NewRequest("OPTIONS", "/account/1", nil)
+	req.Header.Set("Origin", "https://bank.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://bank.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "x-jwt-token")
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), "PATCH")
+	assert.Empty(t, rec.Body.String())
+
+	// Other requests get the headers and reach the handler
+	rec = serve(server, httptest.NewRequest("GET", "/account/1", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "https://bank.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// Any origin is allowed by default
+	server, _ = newTestServer(newTestConfig(t))
+	rec = serve(server, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecation marks an endpoint, or a single field of its response, as scheduled for removal
+type Deprecation struct {
+	Path   string    // Route path template, e.g. /account/{id}
+	Field  string    // Deprecated response field; empty when the whole endpoint is deprecated
+	Sunset time.Time // Date after which the endpoint or field may be removed
+}
+
+// parseDeprecations parses a comma separated list of "path[#field]=YYYY-MM-DD" entries
+func parseDeprecations(spec string) ([]Deprecation, error) {
+	deprecations := []Deprecation{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, date, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid deprecation %q, expected path[#field]=YYYY-MM-DD", entry)
+		}
+
+		sunset, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sunset date in deprecation %q", entry)
+		}
+
+		path, field, _ := strings.Cut(strings.TrimSpace(target), "#")
+		deprecations = append(deprecations, Deprecation{
+			Path:   path,
+			Field:  field,
+			Sunset: sunset,
+		})
+	}
+
+	return deprecations, nil
+}
+
+// deprecationMiddleware adds Deprecation and Sunset headers to responses of deprecated routes
+func deprecationMiddleware(deprecations []Deprecation) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Collect the deprecations that apply to the matched route
+			var sunset time.Time
+			fields := []string{}
+			for _, d := range deprecations {
+				if d.Path != path {
+					continue
+				}
+				if sunset.IsZero() || d.Sunset.Before(sunset) {
+					sunset = d.Sunset
+				}
+				if d.Field != "" {
+					fields = append(fields, d.Field)
+				}
+			}
+
+			// Announce the earliest sunset and list any deprecated fields
+			if !sunset.IsZero() {
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+				if len(fields) > 0 {
+					w.Header().Set("X-Deprecated-Fields", strings.Join(fields, ", "))
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the SQL state Postgres reports when a unique constraint is violated
+const uniqueViolation = "23505"
+
+// errTokenExpired reports a JWT token used past its expiry
+var errTokenExpired = errors.New("token expired")
+
+// errTokenRevoked reports a JWT token used after it was revoked, e.g. by logging out
+var errTokenRevoked = errors.New("token revoked")
+
+// errAccountNotFound reports a lookup of an account that doesn't exist
+var errAccountNotFound = errors.New("not found")
+
+// errTransferNotFound reports a lookup of a pending transfer that doesn't exist
+var errTransferNotFound = errors.New("not found")
+
+// errEmailUnavailable reports a flow that emails a token while no email sender is configured
+var errEmailUnavailable = errors.New("email delivery is not configured")
+
+// statusClientClosedRequest is the non-standard status recorded when the client went away before the response
+const statusClientClosedRequest = 499
+
+// ErrConflict reports that a write collided with an existing record
+type ErrConflict struct {
+	Constraint string // Name of the violated unique constraint
+	Message    string // Human readable reason, if the collision is one clients can act on
+}
+
+func (e *ErrConflict) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("conflict: a record violating %s already exists", e.Constraint)
+}
+
+// ValidationError reports a request rejected because of invalid input
+type ValidationError struct {
+	Message string // Human readable reason
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// validationErrorf returns a ValidationError with the formatted message
+func validationErrorf(format string, args ...any) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// TransferError reports a transfer rejected by a business rule
+type TransferError struct {
+	Code    string // Machine readable reason, e.g. inbound_disabled
+	Message string // Human readable reason
+}
+
+func (e *TransferError) Error() string {
+	return e.Message
+}
+
+// ErrTooManyRequests reports a request rejected because it came too soon after a previous one
+type ErrTooManyRequests struct {
+	Code       string        // Machine readable reason, e.g. transfer_cooldown
+	Message    string        // Human readable reason
+	RetryAfter time.Duration // How long the client should wait before retrying
+}
+
+func (e *ErrTooManyRequests) Error() string {
+	return e.Message
+}
+
+// classifyError maps database errors to typed errors the handlers know how to report
+func classifyError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return &ErrConflict{Constraint: pqErr.Constraint}
+	}
+	return err
+}
+
+// errorStatus picks the HTTP status code reported for an error returned by a handler
+// Errors of no known kind are failures of the server, e.g. of the database
+func errorStatus(err error) int {
+	var validation *ValidationError
+	var transferErr *TransferError
+	var conflict *ErrConflict
+	var tooMany *ErrTooManyRequests
+	switch {
+	case errors.As(err, &validation), errors.As(err, &transferErr), errors.Is(err, errInvalidToken):
+		return http.StatusBadRequest
+	case errors.Is(err, errAccountNotFound), errors.Is(err, errTransferNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	case errors.As(err, &tooMany):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errRequestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errEmailUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCode returns the machine readable code of an error, if it has one
+func errorCode(err error) string {
+	var transferErr *TransferError
+	if errors.As(err, &transferErr) {
+		return transferErr.Code
+	}
+	var tooMany *ErrTooManyRequests
+	if errors.As(err, &tooMany) {
+		return tooMany.Code
+	}
+	return ""
+}
+
+// retryAfter returns the Retry-After header value for an error, empty when the error has none
+func retryAfter(err error) string {
+	var tooMany *ErrTooManyRequests
+	if !errors.As(err, &tooMany) {
+		return ""
+	}
+	// Round up to whole seconds so clients never retry too early
+	return strconv.FormatInt(int64(math.Ceil(tooMany.RetryAfter.Seconds())), 10)
+}
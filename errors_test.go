@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClassifyUniqueViolation tests that a duplicate email insert is reported as ErrConflict
+func TestClassifyUniqueViolation(t *testing.T) {
+	// The error lib/pq returns for a duplicate email
+	dbErr := fmt.Errorf("insert account: %w", &pq.Error{Code: uniqueViolation, Constraint: "account_email_key"})
+
+	var conflict *ErrConflict
+	assert.True(t, errors.As(classifyError(dbErr), &conflict))
+	assert.Equal(t, "account_email_key", conflict.Constraint)
+
+	// Handlers report it as a clean 409 without the raw SQL error
+	handler := makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return classifyError(dbErr)
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/account", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "account_email_key")
+	assert.NotContains(t, rec.Body.String(), "pq:")
+
+	// Other errors are left untouched
+	other := &pq.Error{Code: "23503"}
+	assert.Equal(t, error(other), classifyError(other))
+}
+
+// TestContextErrorsMapped tests that cancelled requests and deadlines get distinct statuses
+func TestContextErrorsMapped(t *testing.T) {
+	handler := makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+		return fmt.Errorf("query account: %w", r.Context().Err())
+	})
+
+	// The client disconnected: no body is written
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/account", nil).WithContext(ctx))
+	assert.Equal(t, statusClientClosedRequest, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	// The deadline fired: the gateway timed out
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/account", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Equal(t, "Gateway Timeout", decodeError(t, rec.Body).Message)
+}
+
+// TestUnclassifiedErrorsHidden tests that errors of no known kind are logged but answered with a generic 500,
+// while validation failures keep their reason
+func TestUnclassifiedErrorsHidden(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dbErr := &pq.Error{Code: "23503", Message: `insert or update on table "transactions" violates foreign key constraint`}
+	handler := requestIDMiddleware(false)(makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("record transaction: %w", dbErr)
+	}))
+	req := httptest.NewRequest("POST", "/transfer", nil)
+	req.Header.Set("X-Request-ID", "upstream-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, ApiError{Message: "internal server error"}, decodeError(t, rec.Body))
+	assert.Contains(t, out.String(), "foreign key constraint")
+	assert.Contains(t, out.String(), "[request upstream-42]")
+
+	validation := makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return validationErrorf("deposit amount must be positive")
+	})
+	rec = httptest.NewRecorder()
+	validation(rec, httptest.NewRequest("POST", "/account/1/deposit", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "deposit amount must be positive", decodeError(t, rec.Body).Message)
+}
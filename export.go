@@ -0,0 +1,46 @@
+package main
+
+import "net/http"
+
+// handleExportAccount sends the data export of an account and its transactions
+// Exports are expensive and sensitive, so each is audit-logged and limited to one per interval
+func (s *APIServer) handleExportAccount(w http.ResponseWriter, r *http.Request) error {
+	actor := accountFromContext(r)
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	export := &AccountExport{ExportedAt: s.now().UTC()}
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		// Allow a single export per interval
+		last, err := tx.LastAuditEntryAt(r.Context(), AuditAccountExport, id)
+		if err != nil {
+			return err
+		}
+		if wait := last.Add(s.config.ExportInterval).Sub(export.ExportedAt); !last.IsZero() && wait > 0 {
+			return &ErrTooManyRequests{Code: "export_rate_limited", Message: "account was exported recently, please try again later", RetryAfter: wait}
+		}
+
+		// Record who exported the account
+		entry := &AuditEntry{ActorID: actor.ID, Action: AuditAccountExport, AccountID: id, CreatedAt: export.ExportedAt}
+		if err := tx.CreateAuditEntry(r.Context(), entry); err != nil {
+			return err
+		}
+
+		if export.Account, err = tx.GetAccountByID(r.Context(), id); err != nil {
+			return err
+		}
+		export.Transactions, err = tx.GetTransactionsByAccount(r.Context(), id, 0, 0)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	logRequestf(r, "account %d exported by account %d", id, actor.ID)
+	hidePrivateNotes(id, export.Transactions)
+	s.presentTransactions(export.Account, export.Transactions)
+	s.presentAccounts(export.Account)
+	return WriteJSON(w, http.StatusOK, export)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccountExportRateLimitedAndAudited tests that exports are audit-logged and limited to one per interval
+func TestAccountExportRateLimitedAndAudited(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	export := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/export", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := export()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(AccountExport)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, acc.ID, resp.Account.ID)
+
+	// The export is audit-logged with who triggered it
+	assert.Len(t, store.audit, 1)
+	assert.Equal(t, AuditEntry{ID: 1, ActorID: acc.ID, Action: AuditAccountExport, AccountID: acc.ID, CreatedAt: now}, *store.audit[0])
+
+	// A second export within the hour is rejected
+	now = now.Add(30 * time.Minute)
+	rec = export()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1800", rec.Header().Get("Retry-After"))
+	assert.Len(t, store.audit, 1)
+
+	now = now.Add(30 * time.Minute)
+	assert.Equal(t, http.StatusOK, export().Code)
+}
+
+// TestAccountExportHidesPrivateNotes tests that the export of the recipient leaves out the sender's private note
+func TestAccountExportHidesPrivateNotes(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, senderToken := newTestAccount(t, store, RoleUser)
+	recipient, recipientToken := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	body := fmt.Sprintf(`{"toAccount":%d,"amount":100,"memo":"rent","note":"paid late again"}`, recipient.Number)
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+	req.Header.Set("x-jwt-token", senderToken)
+	assert.Equal(t, http.StatusOK, serve(server, req).Code)
+
+	export := func(acc *Account, token string) *Transaction {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/export", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		resp := new(AccountExport)
+		decodeData(t, rec.Body, resp)
+		assert.Len(t, resp.Transactions, 1)
+		return resp.Transactions[0]
+	}
+
+	assert.Equal(t, "paid late again", export(sender, senderToken).PrivateNote)
+	received := export(recipient, recipientToken)
+	assert.Equal(t, "rent", received.Memo)
+	assert.Empty(t, received.PrivateNote)
+}
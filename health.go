@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Health states reported by /health
+const (
+	HealthOK          = "ok"          // Reads and writes work
+	HealthDegraded    = "degraded"    // Reads work but the database does not accept writes
+	HealthUnavailable = "unavailable" // The database cannot be reached
+)
+
+// healthCheckTimeout bounds the database check of a single health request
+const healthCheckTimeout = 2 * time.Second
+
+// readOnlyRoutes are routes served in degraded mode even though they use a write method
+var readOnlyRoutes = map[string]bool{
+	"/login":   true,
+	"/refresh": true,
+}
+
+// writingRoutes are routes refused in degraded mode even though they use a read method
+var writingRoutes = map[string]bool{
+	"/activate":            true, // Uses up the token and activates the account
+	"/account/{id}/export": true, // Records the export in the audit log
+}
+
+// checkHealth queries the storage and returns the resulting health state
+func (s *APIServer) checkHealth(ctx context.Context) string {
+	writable, err := s.store.CheckHealth(ctx)
+	switch {
+	case err != nil:
+		return HealthUnavailable
+	case !writable:
+		return HealthDegraded
+	default:
+		return HealthOK
+	}
+}
+
+// healthStatus returns the latest health state
+func (s *APIServer) healthStatus() string {
+	return s.health.Load().(string)
+}
+
+// updateHealth checks the health and stores the resulting state, logging when it changed
+func (s *APIServer) updateHealth(ctx context.Context) string {
+	status := s.checkHealth(ctx)
+	if previous := s.health.Swap(status); previous != status {
+		log.Printf("health changed from %s to %s", previous, status)
+	}
+	return status
+}
+
+// monitorHealth checks the health every interval until ctx is done, logging every change
+func (s *APIServer) monitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.updateHealth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleHealth checks the database and reports the health state; a degraded server is still up, so it answers 200
+// The check runs on every call rather than reporting the last periodic one, so load balancers notice outages at once
+func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status := s.updateHealth(ctx)
+	resp := HealthResponse{Status: status, Writes: "available"}
+	if status != HealthOK {
+		resp.Writes = "unavailable"
+	}
+
+	if status == HealthUnavailable {
+		return WriteJSON(w, http.StatusServiceUnavailable, resp)
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// degradedMiddleware rejects writes with 503 while the server is not healthy, still serving reads
+func (s *APIServer) degradedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.healthStatus() == HealthOK || isReadRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		WriteError(w, http.StatusServiceUnavailable, ApiError{Message: "writes are temporarily unavailable", Code: "degraded"})
+	})
+}
+
+// isReadRequest reports whether the request does not change any data
+func isReadRequest(r *http.Request) bool {
+	var path string
+	if route := mux.CurrentRoute(r); route != nil {
+		path, _ = route.GetPathTemplate()
+	}
+
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return !writingRoutes[path]
+	}
+	return readOnlyRoutes[path]
+}
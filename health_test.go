@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDegradedModeServesReads tests that a degraded server keeps serving reads and rejects writes
+func TestDegradedModeServesReads(t *testing.T) {
+	config := newTestConfig(t)
+	config.DegradedMode = true
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	store.readOnly = true
+
+	// The health check reports that writes are unavailable
+	rec := serve(server, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	health := new(HealthResponse)
+	decodeData(t, rec.Body, health)
+	assert.Equal(t, HealthResponse{Status: HealthDegraded, Writes: "unavailable"}, *health)
+
+	// Reads are served, writes are not
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", sender.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	assert.Equal(t, http.StatusOK, serve(server, req).Code)
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"degraded"`)
+
+	// Reads that write are refused too
+	rec = serve(server, httptest.NewRequest("GET", "/activate?token=abc", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// Once healthy again, writes go through
+	store.readOnly = false
+	server.updateHealth(context.Background())
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestHealthPingsDatabase tests that the unauthenticated health check reports a database outage with 503
+func TestHealthPingsDatabase(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+
+	health := func() (int, HealthResponse) {
+		rec := serve(server, httptest.NewRequest("GET", "/health", nil))
+		resp := HealthResponse{}
+		decodeData(t, rec.Body, &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthOK, resp.Status)
+
+	// The outage shows on the very next check, without waiting for the periodic one
+	store.unavailable = errors.New("connection refused")
+	code, resp = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthUnavailable, resp.Status)
+
+	store.unavailable = nil
+	code, _ = health()
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// interestYear is the period the interest rates of the account types are quoted for
+const interestYear = 365 * 24 * time.Hour
+
+// accruedInterest returns the interest a balance earns over period at the yearly rate, rounded to minor units
+func accruedInterest(balance Money, rate float64, period time.Duration) Money {
+	return Money(math.Round(float64(balance) * rate * float64(period) / float64(interestYear)))
+}
+
+// AccrueInterest credits every interest-bearing account the interest its balance earned over period and
+// records a transaction for it, returning how many accounts were credited
+// Each account is credited in its own transaction, so a failing account doesn't hold back the others;
+// the first error is returned once all accounts were tried
+func (s *APIServer) AccrueInterest(ctx context.Context, period time.Duration) (int, error) {
+	accounts, err := s.store.GetAccounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	credited := 0
+	var firstErr error
+	for _, acc := range accounts {
+		if acc.InterestRate <= 0 || acc.Balance <= 0 || acc.Status == StatusClosed {
+			continue
+		}
+
+		var interest Money
+		var account *Account
+		err := s.store.WithTx(ctx, func(tx Storage) error {
+			// Work from the locked balance, transfers may have moved it since the listing
+			locked, err := tx.LockAccounts(ctx, []int{acc.ID})
+			if err != nil {
+				return err
+			}
+			if len(locked) == 0 {
+				return nil
+			}
+			account = locked[0]
+			if interest = accruedInterest(account.Balance, account.InterestRate, period); interest <= 0 {
+				return nil
+			}
+
+			if err := tx.UpdateBalance(ctx, acc.ID, interest); err != nil {
+				return err
+			}
+			account.Balance += interest
+			return s.recordTransaction(ctx, tx, &Transaction{
+				ToAccount:     acc.ID,
+				Amount:        interest,
+				Kind:          TransactionInterest,
+				Rate:          account.InterestRate,
+				PeriodSeconds: int64(period / time.Second),
+			})
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("accruing interest of account %d: %w", acc.ID, err)
+			}
+			continue
+		}
+		if interest > 0 {
+			credited++
+			s.webhooks.Notify(balanceChange{Account: account, Kind: TransactionInterest, Amount: interest})
+		}
+	}
+	return credited, firstErr
+}
+
+// RunInterestAccrual accrues the interest earned over every interval until ctx is done
+func (s *APIServer) RunInterestAccrual(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			credited, err := s.AccrueInterest(ctx, interval)
+			if err != nil {
+				log.Println("interest accrual failed: ", err)
+			}
+			log.Printf("interest accrued on %d accounts\n", credited)
+		}
+	}
+}
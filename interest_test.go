@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccrueInterest tests that interest-bearing accounts are credited their prorated interest with a transaction,
+// and that other accounts are left alone
+func TestAccrueInterest(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	ctx := context.Background()
+
+	savings, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[savings.ID].InterestRate = 0.0365
+	store.accounts[savings.ID].Balance = 100000
+	checking, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[checking.ID].Balance = 100000
+	closed, _ := newTestAccount(t, store, RoleUser)
+	store.accounts[closed.ID].InterestRate = 0.0365
+	store.accounts[closed.ID].Balance = 100000
+	store.accounts[closed.ID].Status = StatusClosed
+
+	// A day at 3.65% a year earns 0.01% of the balance
+	credited, err := server.AccrueInterest(ctx, 24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, credited)
+	assert.Equal(t, Money(100010), store.accounts[savings.ID].Balance)
+	assert.Equal(t, Money(100000), store.accounts[checking.ID].Balance)
+	assert.Equal(t, Money(100000), store.accounts[closed.ID].Balance)
+
+	if assert.Len(t, store.transactions, 1) {
+		assert.Equal(t, TransactionInterest, store.transactions[0].Kind)
+		assert.Equal(t, savings.ID, store.transactions[0].ToAccount)
+		assert.Equal(t, Money(10), store.transactions[0].Amount)
+		assert.Equal(t, 0.0365, store.transactions[0].Rate)
+		assert.Equal(t, int64(24*60*60), store.transactions[0].PeriodSeconds)
+		assert.NotEmpty(t, store.transactions[0].Reference)
+	}
+
+	// Interest rounding to less than a minor unit is not credited
+	credited, err = server.AccrueInterest(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, credited)
+	assert.Len(t, store.transactions, 1)
+}
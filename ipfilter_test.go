@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAdminRouteIPFilter tests that admin routes are denied to IPs outside the allow list
+func TestAdminRouteIPFilter(t *testing.T) {
+	allow, err := parseCIDRs("10.0.0.0/8")
+	assert.Nil(t, err)
+	deny, err := parseCIDRs("10.6.6.6")
+	assert.Nil(t, err)
+	proxies, err := parseCIDRs("192.168.1.1")
+	assert.Nil(t, err)
+
+	adminRoute := ipFilterMiddleware(allow, deny, proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/admin/accounts", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		adminRoute.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Internal and external clients connecting directly
+	assert.Equal(t, http.StatusOK, call("10.1.2.3:5000", ""))
+	assert.Equal(t, http.StatusForbidden, call("203.0.113.7:5000", ""))
+	// The deny list wins over the allow list
+	assert.Equal(t, http.StatusForbidden, call("10.6.6.6:5000", ""))
+	// Behind the trusted proxy the forwarded client address is used
+	assert.Equal(t, http.StatusOK, call("192.168.1.1:5000", "10.1.2.3"))
+	assert.Equal(t, http.StatusForbidden, call("192.168.1.1:5000", "10.1.2.3, 203.0.113.7"))
+	// Untrusted peers cannot spoof their address
+	assert.Equal(t, http.StatusForbidden, call("203.0.113.7:5000", "10.1.2.3"))
+}
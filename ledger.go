@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LedgerVerification is the result of recomputing the hash chain of an account's transactions
+type LedgerVerification struct {
+	Valid    bool   `json:"valid"`              // Whether every hashed transaction matches the chain
+	Checked  int    `json:"checked"`            // Number of hashed transactions checked
+	BrokenAt string `json:"brokenAt,omitempty"` // Reference of the first transaction failing verification
+}
+
+// transactionHash hashes the contents of a transaction together with the previous hashes of both
+// accounts' chains, so changing any historical transaction breaks every later hash
+func transactionHash(t *Transaction) string {
+	fields := []string{
+		t.Reference,
+		strconv.Itoa(t.FromAccount),
+		strconv.Itoa(t.ToAccount),
+		strconv.FormatInt(int64(t.Amount), 10),
+		t.Kind,
+		// The database keeps timestamps to the microsecond
+		t.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		t.Memo,
+		t.PrivateNote,
+		t.FromPrevHash,
+		t.ToPrevHash,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainTransaction links the transaction to the latest hash of each account it touches and hashes it
+// It must run after the balances were updated, so the locked account rows serialize the chain
+func chainTransaction(ctx context.Context, tx Storage, t *Transaction) error {
+	var err error
+	if t.FromAccount != 0 {
+		if t.FromPrevHash, err = tx.LastTransactionHash(ctx, t.FromAccount); err != nil {
+			return err
+		}
+	}
+	if t.ToAccount != 0 {
+		if t.ToPrevHash, err = tx.LastTransactionHash(ctx, t.ToAccount); err != nil {
+			return err
+		}
+	}
+
+	t.Hash = transactionHash(t)
+	return nil
+}
+
+// verifyLedger recomputes the hash chain of the account's transactions, given newest first
+// Transactions recorded before the chain was enabled carry no hash and are skipped
+func verifyLedger(accountID int, transactions []*Transaction) *LedgerVerification {
+	result := &LedgerVerification{Valid: true}
+
+	prev := ""
+	for i := len(transactions) - 1; i >= 0; i-- {
+		t := transactions[i]
+		if t.Hash == "" {
+			continue
+		}
+
+		linked := t.ToPrevHash
+		if t.FromAccount == accountID {
+			linked = t.FromPrevHash
+		}
+		if linked != prev || transactionHash(t) != t.Hash {
+			result.Valid = false
+			result.BrokenAt = t.Reference
+			return result
+		}
+
+		result.Checked++
+		prev = t.Hash
+	}
+
+	return result
+}
+
+// handleVerifyLedger recomputes the transaction hash chain of an account to detect tampering
+func (s *APIServer) handleVerifyLedger(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	transactions, err := s.store.GetTransactionsByAccount(r.Context(), id, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, verifyLedger(id, transactions))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVerifyLedgerDetectsTampering tests that altering a historical transaction breaks the hash chain
+func TestVerifyLedgerDetectsTampering(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	for _, amount := range []int{100, 200, 300} {
+		rec := serve(server, transferRequest(token, recipient.Number, amount))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	verify := func() *LedgerVerification {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/verify-ledger", sender.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		result := new(LedgerVerification)
+		decodeData(t, rec.Body, result)
+		return result
+	}
+
+	assert.Equal(t, LedgerVerification{Valid: true, Checked: 3}, *verify())
+
+	// Tamper with the amount of the first transfer
+	store.transactions[0].Amount = 1
+	result := verify()
+	assert.False(t, result.Valid)
+	assert.Equal(t, store.transactions[0].Reference, result.BrokenAt)
+}
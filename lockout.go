@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedKeys caps the keys a lockout or penalty tracks, so failures for ever new keys can't exhaust memory
+const maxTrackedKeys = 100000
+
+// lockout counts consecutive failures per key and locks the key for a cooldown once a threshold is reached
+// Failures are forgotten once the key has gone a cooldown without failing and isn't locked
+type lockout struct {
+	mu         sync.Mutex
+	threshold  int              // Consecutive failures that trigger the lockout
+	cooldown   time.Duration    // How long a key stays locked
+	now        func() time.Time // Clock, replaceable in tests
+	maxEntries int              // Most keys tracked at once
+	entries    map[string]*lockoutEntry
+}
+
+// lockoutEntry tracks the failures of a single key
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// newLockout creates a lockout triggered after threshold consecutive failures
+func newLockout(threshold int, cooldown time.Duration) *lockout {
+	return &lockout{
+		threshold:  threshold,
+		cooldown:   cooldown,
+		now:        time.Now,
+		maxEntries: maxTrackedKeys,
+		entries:    map[string]*lockoutEntry{},
+	}
+}
+
+// expired reports whether the entry is neither locked nor has failed within the last cooldown
+func (l *lockout) expired(entry *lockoutEntry, now time.Time) bool {
+	return !now.Before(entry.lockedUntil) && now.Sub(entry.lastFailure) >= l.cooldown
+}
+
+// evict makes room for a new key, dropping the expired entries and, should that not be enough,
+// the one that failed longest ago; the caller must hold the lock
+func (l *lockout) evict(now time.Time) {
+	for key, entry := range l.entries {
+		if l.expired(entry, now) {
+			delete(l.entries, key)
+		}
+	}
+	if len(l.entries) < l.maxEntries {
+		return
+	}
+
+	oldest := ""
+	for key, entry := range l.entries {
+		if oldest == "" || entry.lastFailure.Before(l.entries[oldest].lastFailure) {
+			oldest = key
+		}
+	}
+	delete(l.entries, oldest)
+}
+
+// Locked reports whether the key is locked and for how much longer
+func (l *lockout) Locked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return false, 0
+	}
+	now := l.now()
+	if l.expired(entry, now) {
+		delete(l.entries, key)
+		return false, 0
+	}
+	remaining := entry.lockedUntil.Sub(now)
+	if remaining <= 0 {
+		return false, 0
+	}
+	return true, remaining
+}
+
+// Fail records a failure for the key, locking it once the threshold is reached
+func (l *lockout) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	entry, ok := l.entries[key]
+	if ok && l.expired(entry, now) {
+		ok = false
+	}
+	if !ok {
+		delete(l.entries, key)
+		if len(l.entries) >= l.maxEntries {
+			l.evict(now)
+		}
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= l.threshold {
+		entry.failures = 0
+		entry.lockedUntil = now.Add(l.cooldown)
+	}
+}
+
+// Reset forgets the failures of the key
+func (l *lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
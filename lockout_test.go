@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLockoutForgetsExpiredKeys tests that keys are forgotten once their lockout and failures expire,
+// and that the number of tracked keys stays capped
+func TestLockoutForgetsExpiredKeys(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	l := newLockout(2, time.Minute)
+	l.now = func() time.Time { return now }
+	l.maxEntries = 3
+
+	// A locked key stays tracked until the cooldown has passed
+	l.Fail("locked")
+	l.Fail("locked")
+	l.Fail("once")
+	now = now.Add(time.Minute)
+	locked, _ := l.Locked("locked")
+	assert.False(t, locked)
+	assert.NotContains(t, l.entries, "locked")
+
+	// A failure a cooldown ago no longer counts towards the threshold
+	l.Fail("once")
+	locked, _ = l.Locked("once")
+	assert.False(t, locked)
+
+	// New keys push out the oldest once the cap is reached
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		l.Fail("login-" + strconv.Itoa(i))
+	}
+	assert.Len(t, l.entries, 3)
+	assert.Contains(t, l.entries, "login-9")
+}
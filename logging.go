@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Log levels, from the most to the least verbose
+const (
+	LogLevelDebug = "debug" // Also log the diagnostics of every request, such as authentication
+	LogLevelInfo  = "info"
+)
+
+// debugf logs a diagnostic message about the request when the configured log level is debug
+func debugf(config *Config, r *http.Request, format string, args ...any) {
+	if config.LogLevel == LogLevelDebug {
+		logRequestf(r, "debug: "+format, args...)
+	}
+}
+
+// requestLogger writes the request log, one JSON object per line so log aggregators can ingest it
+var requestLogger = log.New(os.Stdout, "", 0)
+
+// requestLogEntry is the request log line of a handled request
+type requestLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMs float64   `json:"latencyMs"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// statusRecorder captures the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// loggingMiddleware logs the method, path, status code and latency of every request
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// A handler that wrote nothing answered 200
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		line, err := json.Marshal(requestLogEntry{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID: w.Header().Get(requestIDHeader),
+		})
+		if err != nil {
+			log.Println("request log:", err)
+			return
+		}
+		requestLogger.Println(string(line))
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoggingMiddleware tests that every request is logged as a JSON line with its status and latency
+func TestLoggingMiddleware(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	var out bytes.Buffer
+	requestLogger.SetOutput(&out)
+	t.Cleanup(func() { requestLogger.SetOutput(os.Stdout) })
+	handler := loggingMiddleware(server.router())
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil))
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	entry := new(requestLogEntry)
+	assert.Nil(t, json.Unmarshal(lines[0], entry))
+	assert.Equal(t, "GET", entry.Method)
+	assert.Equal(t, fmt.Sprintf("/account/%d", acc.ID), entry.Path)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.True(t, entry.LatencyMs >= 0)
+	assert.NotEmpty(t, entry.RequestID)
+
+	// The status set by the handler is captured
+	assert.Nil(t, json.Unmarshal(lines[1], entry))
+	assert.Equal(t, http.StatusForbidden, entry.Status)
+}
+
+// TestDebugLogging tests that debug messages are only logged at the debug level
+func TestDebugLogging(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	config := newTestConfig(t)
+	server, _ := newTestServer(config)
+	serve(server, httptest.NewRequest("GET", "/account/1", nil))
+	assert.Empty(t, out.String())
+
+	config.LogLevel = LogLevelDebug
+	serve(server, httptest.NewRequest("GET", "/account/1", nil))
+	assert.Contains(t, out.String(), "debug: authenticating GET /account/1")
+}
@@ -1,60 +1,223 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
-// seedAccount creates and stores a new account with the given details
-func seedAccount(store Storage, fname, lname, pw string) *Account {
+// seedSpec declares an account provided by the seed, keyed by its account number
+type seedSpec struct {
+	FirstName string
+	LastName  string
+	Password  string
+	Type      string
+	Number    int64 // Account number, or 0 to assign one following the account number policy
+	Balance   Money
+}
+
+// seedSpecs are the accounts the database is seeded with
+// Their numbers are assigned, so they are valid whatever the configured account number policy
+var seedSpecs = []seedSpec{
+	{FirstName: "anthony", LastName: "GG", Password: "hunter88888", Type: "checking", Balance: 0},
+}
+
+// seedAccount creates the seeded account, or resets it to the declared values when it already exists
+func seedAccount(ctx context.Context, store Storage, config *Config, spec seedSpec) (*Account, error) {
+	// Look up the rules of the declared account type
+	accType, err := config.AccountType(spec.Type)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a new account with the provided details
-	acc, err := NewAccount(fname, lname, pw)
+	acc, err := NewAccount(spec.FirstName, spec.LastName, spec.Password, accType)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	acc.Balance = spec.Balance
+	acc.Currency = config.DefaultCurrency
+	acc.Activated = true
 
-	// Store the newly created account in the storage
-	if err := store.CreateAccount(acc); err != nil {
-		log.Fatal(err)
+	// A declared number must be one the account could log in with
+	acc.Number = spec.Number
+	if acc.Number == 0 {
+		if acc.Number, err = config.AccountNumbers.Assign(ctx, store); err != nil {
+			return nil, err
+		}
+	} else if err := config.AccountNumbers.ValidateAssignable(acc.Number); err != nil {
+		return nil, fmt.Errorf("seed account %s %s: %w", spec.FirstName, spec.LastName, err)
+	}
+
+	// Insert or update the account keyed by its number, so re-seeding yields the same state
+	if err := store.UpsertAccount(ctx, acc); err != nil {
+		return nil, err
 	}
 
-	// Print the account number of the newly created account
-	fmt.Println("new account => ", acc.Number)
+	// Print the account number of the seeded account
+	fmt.Println("seeded account => ", acc.Number)
 
-	// Return the created account
-	return acc
+	// Return the seeded account
+	return acc, nil
 }
 
-// seedAccounts seeds the database with predefined accounts
-func seedAccounts(s Storage) {
-	// Add a specific account to the database
-	seedAccount(s, "anthony", "GG", "hunter88888")
+// seedAccounts seeds an empty database with predefined accounts, leaving a populated one alone
+// With force, every stored account and its data is deleted first, which is refused in production
+// It reports whether the accounts were seeded
+func seedAccounts(ctx context.Context, s Storage, config *Config, force bool) (bool, error) {
+	if force {
+		if config.Environment == "production" {
+			return false, fmt.Errorf("refusing to force seeding in the %s environment", config.Environment)
+		}
+		if err := s.Truncate(ctx); err != nil {
+			return false, err
+		}
+		log.Println("deleted all accounts before seeding")
+	} else {
+		count, err := s.CountAccounts(ctx, AccountFilter{IncludeDeleted: true})
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			log.Printf("database already holds %d accounts, skipping seed (use -seed-force to re-seed)\n", count)
+			return false, nil
+		}
+	}
+
+	for _, spec := range seedSpecs {
+		if _, err := seedAccount(ctx, s, config, spec); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// bootstrapAdmin creates the configured admin account unless an admin already exists
+// It returns the created account, or nil when nothing was created
+func bootstrapAdmin(ctx context.Context, store Storage, config *Config) (*Account, error) {
+	spec := config.BootstrapAdmin
+	if spec == nil {
+		return nil, nil
+	}
+
+	exists, err := store.AdminExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		log.Println("admin account exists, skipping admin bootstrap")
+		return nil, nil
+	}
+
+	accType, err := config.AccountType("")
+	if err != nil {
+		return nil, err
+	}
+	acc, err := NewAccount(spec.FirstName, spec.LastName, spec.Password, accType)
+	if err != nil {
+		return nil, err
+	}
+	acc.Role = RoleAdmin
+	acc.Email = spec.Email
+	acc.Currency = config.DefaultCurrency
+	acc.Activated = true
+	acc.Number = spec.Number
+	if acc.Number == 0 {
+		if acc.Number, err = config.AccountNumbers.Assign(ctx, store); err != nil {
+			return nil, err
+		}
+	} else if err := config.AccountNumbers.ValidateAssignable(acc.Number); err != nil {
+		return nil, err
+	}
+
+	if err := store.CreateAccount(ctx, acc); err != nil {
+		return nil, err
+	}
+
+	log.Printf("bootstrapped admin account %d with account number %d\n", acc.ID, acc.Number)
+	return acc, nil
 }
 
 func main() {
 	// Define a command-line flag to indicate whether to seed the database
 	seed := flag.Bool("seed", false, "seed the db")
+	seedForce := flag.Bool("seed-force", false, "delete all accounts and seed the db, refused in production")
 	flag.Parse()
 
+	// Load the configuration from the environment
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Create a new instance of the Postgres store
-	store, err := NewPostgresStore()
+	store, err := NewPostgresStore(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Initialize the Postgres store (e.g., create tables, setup schema)
+	// Bring the database schema up to date by applying the pending migrations
 	if err := store.Init(); err != nil {
 		log.Fatal(err)
 	}
 
+	// Stop the server and the background workers on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Check if the seed flag is set; if so, seed the database with accounts
-	if *seed {
+	// Seeding runs before the admin bootstrap, so a fresh database is still seen as empty
+	if *seed || *seedForce {
 		fmt.Println("seeding the database")
-		seedAccounts(store)
+		if _, err := seedAccounts(ctx, store, config, *seedForce); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Create the first admin account of a fresh deployment
+	if _, err := bootstrapAdmin(ctx, store, config); err != nil {
+		log.Fatal(err)
+	}
+
+	// The background workers are waited for on shutdown, so none is cut off halfway through a run
+	var workers sync.WaitGroup
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
+	// Periodically export the accounts when a backup directory is configured
+	if config.BackupDir != "" {
+		exporter := NewBackupExporter(store, config.BackupDir, config.BackupRetain)
+		runWorker(func() { exporter.Run(ctx, config.BackupInterval) })
 	}
 
 	// Create and run the API server
-	server := NewAPIServer(":3000", store)
-	server.Run()
+	server := NewAPIServer(config.ListenAddr, store, config)
+
+	// Periodically drop the revocations of tokens that expired since
+	runWorker(func() { server.RunRevocationPurge(ctx, config.RevocationPurge) })
+
+	// Periodically credit the interest earned by the accounts when an interval is configured
+	if config.InterestInterval > 0 {
+		runWorker(func() { server.RunInterestAccrual(ctx, config.InterestInterval) })
+	}
+
+	err = server.Run(ctx)
+
+	// Stop the workers as well when the server failed, and let them finish their current run
+	stop()
+	log.Println("waiting for background workers to stop")
+	workers.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
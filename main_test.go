@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReseedUpdatesAccount tests that re-seeding with a changed balance updates rather than duplicates
+func TestReseedUpdatesAccount(t *testing.T) {
+	store := newMemStore()
+	config := newTestConfig(t)
+	spec := seedSpec{FirstName: "anthony", LastName: "GG", Password: "hunter88888", Number: 100001, Balance: 500}
+
+	first, err := seedAccount(context.Background(), store, config, spec)
+	assert.Nil(t, err)
+
+	// Seed again with a different balance
+	spec.Balance = 750
+	second, err := seedAccount(context.Background(), store, config, spec)
+	assert.Nil(t, err)
+
+	accounts, err := store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, Money(750), accounts[0].Balance)
+	assert.True(t, accounts[0].ValidPassword("hunter88888"))
+}
+
+// TestSeedAccounts tests that seeding fills an empty database, skips a populated one and re-seeds when forced
+func TestSeedAccounts(t *testing.T) {
+	store := newMemStore()
+	config := newTestConfig(t)
+
+	// An empty database is seeded
+	seeded, err := seedAccounts(context.Background(), store, config, false)
+	assert.Nil(t, err)
+	assert.True(t, seeded)
+	accounts, err := store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, accounts, len(seedSpecs))
+	acc := accounts[0]
+
+	// A populated one is left alone
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 250))
+	other, _ := newTestAccount(t, store, RoleUser)
+	seeded, err = seedAccounts(context.Background(), store, config, false)
+	assert.Nil(t, err)
+	assert.False(t, seeded)
+	acc, _ = store.GetAccountByNumber(context.Background(), int(acc.Number))
+	assert.Equal(t, Money(250), acc.Balance)
+
+	// Forcing wipes every account and seeds again
+	seeded, err = seedAccounts(context.Background(), store, config, true)
+	assert.Nil(t, err)
+	assert.True(t, seeded)
+	accounts, err = store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, accounts, len(seedSpecs))
+	assert.Equal(t, seedSpecs[0].Balance, accounts[0].Balance)
+	_, err = store.GetAccountByNumber(context.Background(), int(other.Number))
+	assert.NotNil(t, err)
+
+	// Never in production
+	config.Environment = "production"
+	seeded, err = seedAccounts(context.Background(), store, config, true)
+	assert.ErrorContains(t, err, "refusing to force seeding")
+	assert.False(t, seeded)
+	count, err := store.CountAccounts(context.Background(), AccountFilter{IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, len(seedSpecs), count)
+}
+
+// TestSeedAccountNumbersFollowPolicy tests that seeded accounts get numbers they can log in with
+func TestSeedAccountNumbersFollowPolicy(t *testing.T) {
+	store := newMemStore()
+	config := newTestConfig(t)
+	config.AccountNumbers.Luhn = true
+
+	_, err := seedAccounts(context.Background(), store, config, false)
+	assert.Nil(t, err)
+	accounts, err := store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, config.AccountNumbers.Validate(accounts[0].Number))
+
+	// A declared number breaking the policy is refused
+	spec := seedSpec{FirstName: "anthony", LastName: "GG", Password: "hunter88888", Number: 100001}
+	_, err = seedAccount(context.Background(), store, config, spec)
+	assert.ErrorContains(t, err, "check digit mismatch")
+}
+
+// TestBootstrapAdminOnce tests that the admin is bootstrapped on the first run only
+func TestBootstrapAdminOnce(t *testing.T) {
+	store := newMemStore()
+	config := newTestConfig(t)
+	config.BootstrapAdmin = &BootstrapAdmin{FirstName: "root", LastName: "admin", Password: "s3cret-admin"}
+
+	admin, err := bootstrapAdmin(context.Background(), store, config)
+	assert.Nil(t, err)
+	assert.NotNil(t, admin)
+	assert.True(t, admin.IsAdmin())
+	assert.Nil(t, config.AccountNumbers.Validate(admin.Number))
+
+	// Later runs leave the existing admin alone
+	again, err := bootstrapAdmin(context.Background(), store, config)
+	assert.Nil(t, err)
+	assert.Nil(t, again)
+
+	accounts, err := store.GetAccounts(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, accounts, 1)
+	assert.True(t, accounts[0].ValidPassword("s3cret-admin"))
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore is an in-memory Storage implementation used by the handler tests
+type memStore struct {
+	mu            sync.Mutex
+	accounts      map[int]*Account
+	nextID        int
+	numberLookups int // Number of GetAccountByNumber calls
+	transactions  []*Transaction
+	transferSeq   int64
+	tokens        []*AccountToken
+	pending       []*PendingTransfer
+	audit         []*AuditEntry
+	rowLocks      map[int]*sync.Mutex // Emulated row locks taken by LockAccounts within WithTx
+	numberChanges []*AccountNumberChange
+	revoked       map[string]time.Time // Expiry of every revoked JWT token, by token ID
+	readOnly      bool                 // Report the database as not accepting writes
+	unavailable   error                // Report the database as unreachable with this error
+}
+
+// newMemStore creates an empty in-memory store
+func newMemStore() *memStore {
+	return &memStore{
+		accounts: map[int]*Account{},
+		nextID:   1,
+		rowLocks: map[int]*sync.Mutex{},
+		revoked:  map[string]time.Time{},
+	}
+}
+
+func (m *memStore) CreateAccount(ctx context.Context, acc *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror the unique index on the number, which also covers deleted accounts
+	for _, stored := range m.accounts {
+		if stored.Number == acc.Number {
+			return &ErrConflict{Constraint: accountNumberKey}
+		}
+		if acc.Email != "" && stored.Email == acc.Email {
+			return &ErrConflict{Constraint: accountEmailKey}
+		}
+	}
+
+	acc.ID = m.nextID
+	m.nextID++
+	stored := *acc
+	m.accounts[acc.ID] = &stored
+	return nil
+}
+
+func (m *memStore) DeleteAccount(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if acc, ok := m.accounts[id]; ok && acc.DeletedAt == nil {
+		now := time.Now().UTC()
+		acc.DeletedAt = &now
+	}
+	return nil
+}
+
+// account returns the account with the given ID unless it is missing or deleted; m.mu must be held
+func (m *memStore) account(id int) (*Account, bool) {
+	acc, ok := m.accounts[id]
+	if !ok || acc.DeletedAt != nil {
+		return nil, false
+	}
+	return acc, true
+}
+
+func (m *memStore) UpdateAccount(ctx context.Context, id int, firstName, lastName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.FirstName = firstName
+	acc.LastName = lastName
+	return nil
+}
+
+func (m *memStore) GetAccounts(ctx context.Context) ([]*Account, error) {
+	return m.listAccounts(false), nil
+}
+
+// listAccounts returns copies of the stored accounts in ID order
+func (m *memStore) listAccounts(includeDeleted bool) []*Account {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accounts := []*Account{}
+	for id := 1; id < m.nextID; id++ {
+		if acc, ok := m.accounts[id]; ok && (includeDeleted || acc.DeletedAt == nil) {
+			copied := *acc
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts
+}
+
+func (m *memStore) GetAccountByID(ctx context.Context, id int) (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.account(id)
+	if !ok {
+		return nil, fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	copied := *acc
+	return &copied, nil
+}
+
+func (m *memStore) GetAccountByNumber(ctx context.Context, number int) (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.numberLookups++
+	for _, acc := range m.accounts {
+		if acc.Number == int64(number) && acc.DeletedAt == nil {
+			copied := *acc
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("account with number [%d] %w", number, errAccountNotFound)
+}
+
+func (m *memStore) GetAccountsPaginated(ctx context.Context, limit, offset int, filter AccountFilter) ([]*Account, error) {
+	accounts := m.filterAccounts(filter)
+	if offset >= len(accounts) {
+		return []*Account{}, nil
+	}
+	accounts = accounts[offset:]
+	if limit < len(accounts) {
+		accounts = accounts[:limit]
+	}
+	return accounts, nil
+}
+
+func (m *memStore) GetAccountsAfter(ctx context.Context, cursorID, limit int, filter AccountFilter) ([]*Account, error) {
+	accounts := []*Account{}
+	for _, acc := range m.filterAccounts(filter) {
+		if acc.ID > cursorID && len(accounts) < limit {
+			accounts = append(accounts, acc)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *memStore) CountAccounts(ctx context.Context, filter AccountFilter) (int, error) {
+	return len(m.filterAccounts(filter)), nil
+}
+
+// filterAccounts returns copies of the accounts matching the filter, ordered by ID
+func (m *memStore) filterAccounts(filter AccountFilter) []*Account {
+	accounts := []*Account{}
+	for _, acc := range m.listAccounts(filter.IncludeDeleted) {
+		if !filter.CreatedAfter.IsZero() && acc.CreatedAt.Before(filter.CreatedAfter) {
+			continue
+		}
+		if !filter.CreatedBefore.IsZero() && acc.CreatedAt.After(filter.CreatedBefore) {
+			continue
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts
+}
+
+func (m *memStore) SearchAccounts(ctx context.Context, query string) ([]*Account, error) {
+	matches := []*Account{}
+	for _, acc := range m.listAccounts(false) {
+		if strings.Contains(strings.ToLower(acc.FirstName+" "+acc.LastName), strings.ToLower(query)) {
+			matches = append(matches, acc)
+		}
+	}
+	return matches, nil
+}
+
+func (m *memStore) Truncate(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accounts = map[int]*Account{}
+	m.nextID = 1
+	m.transactions = nil
+	m.tokens = nil
+	m.pending = nil
+	m.audit = nil
+	m.numberChanges = nil
+	return nil
+}
+
+func (m *memStore) GetAccountsByIDs(ctx context.Context, ids []int) ([]*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accounts := []*Account{}
+	for _, id := range ids {
+		if acc, ok := m.account(id); ok {
+			copied := *acc
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *memStore) AccountNumberExists(ctx context.Context, number int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, acc := range m.accounts {
+		if acc.Number == number {
+			return true, nil
+		}
+	}
+	for _, c := range m.numberChanges {
+		if c.OldNumber == number {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memStore) UpdateAccountNumber(ctx context.Context, id int, number int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.Number = number
+	return nil
+}
+
+func (m *memStore) CreateAccountNumberChange(ctx context.Context, c *AccountNumberChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.ID = len(m.numberChanges) + 1
+	stored := *c
+	m.numberChanges = append(m.numberChanges, &stored)
+	return nil
+}
+
+func (m *memStore) UpsertAccount(ctx context.Context, acc *Account) error {
+	m.mu.Lock()
+	for id, stored := range m.accounts {
+		if stored.Number == acc.Number {
+			acc.ID = id
+			acc.CreatedAt = stored.CreatedAt
+			updated := *acc
+			m.accounts[id] = &updated
+			m.mu.Unlock()
+			return nil
+		}
+	}
+	m.mu.Unlock()
+
+	return m.CreateAccount(ctx, acc)
+}
+
+// WithTx restores the accounts as they were before fn when fn fails
+func (m *memStore) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	m.mu.Lock()
+	snapshot := map[int]*Account{}
+	for id, acc := range m.accounts {
+		copied := *acc
+		snapshot[id] = &copied
+	}
+	nextID := m.nextID
+	transactions := append([]*Transaction{}, m.transactions...)
+	pending := []*PendingTransfer{}
+	for _, p := range m.pending {
+		copied := *p
+		pending = append(pending, &copied)
+	}
+	m.mu.Unlock()
+
+	tx := &memTx{memStore: m}
+	defer tx.unlock()
+	if err := fn(tx); err != nil {
+		m.mu.Lock()
+		m.accounts = snapshot
+		m.nextID = nextID
+		m.transactions = transactions
+		m.pending = pending
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (m *memStore) LockAccounts(ctx context.Context, ids []int) ([]*Account, error) {
+	return m.GetAccountsByIDs(ctx, ids)
+}
+
+// memTx is the Storage handed to WithTx callbacks; it holds the row locks taken by LockAccounts until the
+// transaction ends, blocking other transactions that lock the same accounts like Postgres would
+type memTx struct {
+	*memStore
+	held []*sync.Mutex
+}
+
+func (t *memTx) LockAccounts(ctx context.Context, ids []int) ([]*Account, error) {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	for i, id := range sorted {
+		if i > 0 && sorted[i-1] == id {
+			continue
+		}
+		t.mu.Lock()
+		lock, ok := t.rowLocks[id]
+		if !ok {
+			lock = &sync.Mutex{}
+			t.rowLocks[id] = lock
+		}
+		t.mu.Unlock()
+
+		lock.Lock()
+		t.held = append(t.held, lock)
+	}
+	return t.GetAccountsByIDs(ctx, sorted)
+}
+
+// unlock releases the row locks held by the transaction
+func (t *memTx) unlock() {
+	for _, lock := range t.held {
+		lock.Unlock()
+	}
+	t.held = nil
+}
+
+func (m *memStore) UpdateAccountStatus(ctx context.Context, id int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.Status = status
+	return nil
+}
+
+func (m *memStore) UpdateBalance(ctx context.Context, id int, delta Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.Balance += delta
+	return nil
+}
+
+func (m *memStore) SetWebhookURL(ctx context.Context, id int, url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.WebhookURL = url
+	return nil
+}
+
+func (m *memStore) SetAcceptsInbound(ctx context.Context, id int, accepts bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.AcceptsInbound = accepts
+	return nil
+}
+
+func (m *memStore) UpdatePin(ctx context.Context, id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.EncryptedPin = hash
+	return nil
+}
+
+func (m *memStore) NextTransactionSeq(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transferSeq++
+	return m.transferSeq, nil
+}
+
+func (m *memStore) CreateTransaction(ctx context.Context, t *Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.transactions {
+		if stored.Reference == t.Reference {
+			return &ErrConflict{Constraint: "transactions_reference_key"}
+		}
+	}
+	t.ID = len(m.transactions) + 1
+	stored := *t
+	m.transactions = append(m.transactions, &stored)
+	return nil
+}
+
+func (m *memStore) SetActivated(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.Activated = true
+	return nil
+}
+
+func (m *memStore) CreateAccountToken(ctx context.Context, t *AccountToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = len(m.tokens) + 1
+	stored := *t
+	m.tokens = append(m.tokens, &stored)
+	return nil
+}
+
+func (m *memStore) ConsumeAccountToken(ctx context.Context, purpose, hash string, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.Purpose == purpose && t.TokenHash == hash && t.UsedAt == nil && t.ExpiresAt.After(now) {
+			usedAt := now
+			t.UsedAt = &usedAt
+			return t.AccountID, nil
+		}
+	}
+	return 0, errInvalidToken
+}
+
+func (m *memStore) GetAccountByEmail(ctx context.Context, email string) (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, acc := range m.accounts {
+		if email != "" && acc.Email == email && acc.DeletedAt == nil {
+			copied := *acc
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("account with email [%s] %w", email, errAccountNotFound)
+}
+
+func (m *memStore) UpdatePassword(ctx context.Context, id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc, ok := m.accounts[id]
+	if !ok {
+		return fmt.Errorf("account %d %w", id, errAccountNotFound)
+	}
+	acc.EncryptedPassword = hash
+	return nil
+}
+
+func (m *memStore) SumTransfers(ctx context.Context, fromID, toID int, since time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total Money
+	for _, t := range m.transactions {
+		if t.Kind == TransactionTransfer && t.FromAccount == fromID && t.ToAccount == toID && !t.CreatedAt.Before(since) {
+			total += t.Amount
+		}
+	}
+	return int64(total), nil
+}
+
+func (m *memStore) SumOutgoingTransfersSince(ctx context.Context, accountID int, since time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total Money
+	for _, t := range m.transactions {
+		if t.Kind == TransactionTransfer && t.FromAccount == accountID && !t.CreatedAt.Before(since) {
+			total += t.Amount
+		}
+	}
+	return int64(total), nil
+}
+
+func (m *memStore) TransferStats(ctx context.Context, fromID int) (int, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count, total := 0, Money(0)
+	for _, t := range m.transactions {
+		if t.Kind == TransactionTransfer && t.FromAccount == fromID {
+			count++
+			total += t.Amount
+		}
+	}
+	if count == 0 {
+		return 0, 0, nil
+	}
+	return count, float64(total) / float64(count), nil
+}
+
+func (m *memStore) CheckHealth(context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.unavailable != nil {
+		return false, m.unavailable
+	}
+	return !m.readOnly, nil
+}
+
+func (m *memStore) AdminExists(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, acc := range m.accounts {
+		if acc.IsAdmin() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memStore) GetTransactionsByAccount(ctx context.Context, accountID, limit, offset int) ([]*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := []*Transaction{}
+	for i := len(m.transactions) - 1; i >= 0; i-- {
+		if t := m.transactions[i]; t.FromAccount == accountID || t.ToAccount == accountID {
+			copied := *t
+			transactions = append(transactions, &copied)
+		}
+	}
+
+	if offset >= len(transactions) {
+		return []*Transaction{}, nil
+	}
+	transactions = transactions[offset:]
+	if limit > 0 && limit < len(transactions) {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+func (m *memStore) GetTransactionsBefore(ctx context.Context, accountID, cursorID, limit int) ([]*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := []*Transaction{}
+	for i := len(m.transactions) - 1; i >= 0 && len(transactions) < limit; i-- {
+		t := m.transactions[i]
+		if (t.FromAccount == accountID || t.ToAccount == accountID) && (cursorID == 0 || t.ID < cursorID) {
+			copied := *t
+			transactions = append(transactions, &copied)
+		}
+	}
+	return transactions, nil
+}
+
+func (m *memStore) GetTransactionsAfter(ctx context.Context, cursorID, limit int) ([]*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := []*Transaction{}
+	for _, t := range m.transactions {
+		if t.ID > cursorID && len(transactions) < limit {
+			copied := *t
+			transactions = append(transactions, &copied)
+		}
+	}
+	return transactions, nil
+}
+
+func (m *memStore) GetTransactionsSince(ctx context.Context, accountID int, since time.Time) ([]*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := []*Transaction{}
+	for _, t := range m.transactions {
+		if (t.FromAccount == accountID || t.ToAccount == accountID) && !t.CreatedAt.Before(since) {
+			copied := *t
+			transactions = append(transactions, &copied)
+		}
+	}
+	return transactions, nil
+}
+
+func (m *memStore) LastTransferAt(ctx context.Context, accountID int) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last time.Time
+	for _, t := range m.transactions {
+		if t.Kind == TransactionTransfer && t.FromAccount == accountID && t.CreatedAt.After(last) {
+			last = t.CreatedAt
+		}
+	}
+	return last, nil
+}
+
+func (m *memStore) CreatePendingTransfer(ctx context.Context, p *PendingTransfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.ID = len(m.pending) + 1
+	stored := *p
+	m.pending = append(m.pending, &stored)
+	return nil
+}
+
+func (m *memStore) GetPendingTransfer(ctx context.Context, id int) (*PendingTransfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.pending) {
+		return nil, fmt.Errorf("pending transfer %d %w", id, errTransferNotFound)
+	}
+	copied := *m.pending[id-1]
+	return &copied, nil
+}
+
+func (m *memStore) UpdatePendingTransfer(ctx context.Context, id int, status string, approvedBy int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.pending) {
+		return fmt.Errorf("pending transfer %d %w", id, errTransferNotFound)
+	}
+	m.pending[id-1].Status = status
+	m.pending[id-1].ApprovedBy = approvedBy
+	return nil
+}
+
+func (m *memStore) CancelExpiredTransfers(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var cancelled int64
+	for _, p := range m.pending {
+		if p.Status == TransferPendingApproval && !p.ExpiresAt.After(now) {
+			p.Status = TransferCancelled
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
+
+func (m *memStore) LastTransactionHash(ctx context.Context, accountID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.transactions) - 1; i >= 0; i-- {
+		if t := m.transactions[i]; (t.FromAccount == accountID || t.ToAccount == accountID) && t.Hash != "" {
+			return t.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+func (m *memStore) CreateAuditEntry(ctx context.Context, e *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = len(m.audit) + 1
+	stored := *e
+	m.audit = append(m.audit, &stored)
+	return nil
+}
+
+func (m *memStore) LastAuditEntryAt(ctx context.Context, action string, accountID int) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last time.Time
+	for _, e := range m.audit {
+		if e.Action == action && e.AccountID == accountID && e.CreatedAt.After(last) {
+			last = e.CreatedAt
+		}
+	}
+	return last, nil
+}
+
+func (m *memStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *memStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *memStore) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for jti, expiresAt := range m.revoked {
+		if !expiresAt.After(now) {
+			delete(m.revoked, jti)
+			purged++
+		}
+	}
+	return purged, nil
+}
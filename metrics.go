@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus metrics of a server, in a registry of its own so that servers don't share them
+type metrics struct {
+	registry     *prometheus.Registry
+	requests     prometheus.Counter     // Requests handled
+	responses    *prometheus.CounterVec // Requests handled by status code
+	latency      prometheus.Histogram   // Time taken to handle a request
+	sharedLogins prometheus.Counter     // Logins answered by a password check shared with identical concurrent ones
+}
+
+// newMetrics registers the request and login metrics and a gauge of the accounts in the store
+func newMetrics(store Storage) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gobank_http_requests_total",
+			Help: "Number of HTTP requests handled.",
+		}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobank_http_responses_total",
+			Help: "Number of HTTP requests handled by status code.",
+		}, []string{"code"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gobank_http_request_duration_seconds",
+			Help:    "Time taken to handle an HTTP request.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		sharedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gobank_logins_shared_total",
+			Help: "Number of logins answered by a password check shared with identical concurrent logins.",
+		}),
+	}
+
+	// Count the accounts at scrape time; a failed count is reported as NaN rather than a misleading zero
+	accounts := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gobank_accounts",
+		Help: "Number of accounts, not counting the deleted ones.",
+	}, func() float64 {
+		count, err := store.CountAccounts(context.Background(), AccountFilter{})
+		if err != nil {
+			log.Println("metrics: counting accounts:", err)
+			return math.NaN()
+		}
+		return float64(count)
+	})
+
+	m.registry.MustRegister(m.requests, m.responses, m.latency, m.sharedLogins, accounts)
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware counts every request by status code and observes its latency
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// A handler that wrote nothing answered 200
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		m.requests.Inc()
+		m.responses.WithLabelValues(strconv.Itoa(rec.status)).Inc()
+		m.latency.Observe(time.Since(start).Seconds())
+	})
+}
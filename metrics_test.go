@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetrics tests that handled requests and the account count are exposed on /metrics
+func TestMetrics(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	newTestAccount(t, store, RoleUser)
+	handler := server.metrics.middleware(server.router())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "gobank_http_requests_total 2")
+	assert.Contains(t, body, `gobank_http_responses_total{code="200"} 1`)
+	assert.Contains(t, body, `gobank_http_responses_total{code="404"} 1`)
+	assert.Contains(t, body, "gobank_http_request_duration_seconds_count 2")
+	assert.Contains(t, body, "gobank_accounts 1")
+}
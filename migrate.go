@@ -0,0 +1,113 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the schema migrations, numbered SQL files applied in order of their number
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a versioned change to the database schema
+type migration struct {
+	Version int    // Number the file name starts with, e.g. 2 for 0002_create_transactions.sql
+	Name    string // File name
+	SQL     string // Statements to run
+}
+
+// loadMigrations reads the migrations of the directory, sorted by version
+// Every file must be named <version>_<description>.sql, with each version used once
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []migration{}
+	versions := map[int]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" {
+			continue
+		}
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", name)
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		versions[version] = name
+
+		sql, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: name, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Init brings the database schema up to date by applying the migrations not applied yet,
+// each in a transaction of its own recorded in the 'schema_migrations' table
+func (s *PostgresStore) Init() error {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+
+	query := `create table if not exists schema_migrations (
+		version integer primary key,
+		name varchar(200) not null,
+		applied_at timestamp not null default now()
+	)`
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the migration unless it was applied already
+// Concurrent startups serialize on an advisory lock, so each migration runs exactly once
+func (s *PostgresStore) applyMigration(m migration) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("select pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+		return err
+	}
+	var applied bool
+	if err := tx.QueryRow("select exists (select 1 from schema_migrations where version = $1)", m.Version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("insert into schema_migrations (version, name) values ($1, $2)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
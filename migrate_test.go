@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadMigrations tests that migrations are applied in version order and misnamed ones are rejected
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_add_index.sql":    {Data: []byte("create index x on y (z)")},
+		"migrations/0002_create_table.sql": {Data: []byte("create table y (z int)")},
+		"migrations/README":                {Data: []byte("not a migration")},
+	}
+	migrations, err := loadMigrations(fsys, "migrations")
+	assert.Nil(t, err)
+	assert.Equal(t, []migration{
+		{Version: 2, Name: "0002_create_table.sql", SQL: "create table y (z int)"},
+		{Version: 10, Name: "0010_add_index.sql", SQL: "create index x on y (z)"},
+	}, migrations)
+
+	fsys["migrations/2_duplicate.sql"] = &fstest.MapFile{Data: []byte("select 1")}
+	_, err = loadMigrations(fsys, "migrations")
+	assert.EqualError(t, err, "migrations 0002_create_table.sql and 2_duplicate.sql share version 2")
+
+	delete(fsys, "migrations/2_duplicate.sql")
+	fsys["migrations/add_column.sql"] = &fstest.MapFile{Data: []byte("select 1")}
+	_, err = loadMigrations(fsys, "migrations")
+	assert.EqualError(t, err, "migration add_column.sql: name must start with a positive version number")
+
+	// The embedded migrations are well formed
+	migrations, err = loadMigrations(migrationFiles, "migrations")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, migrations[0].Version)
+}
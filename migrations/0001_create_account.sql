@@ -0,0 +1,38 @@
+-- Accounts and the columns added to them before migrations were versioned; every statement is
+-- idempotent so databases created by the older inline schema setup upgrade in place
+create table if not exists account (
+	id serial primary key,
+	first_name varchar(100),
+	last_name varchar(100),
+	number serial,
+	encrypted_password varchar(100),
+	balance serial,
+	created_at timestamp,
+	role varchar(20) not null default 'user',
+	type varchar(20) not null default 'checking',
+	min_balance bigint not null default 0,
+	interest_rate double precision not null default 0,
+	accepts_inbound boolean not null default true,
+	encrypted_pin varchar(100) not null default '',
+	email varchar(254) not null default '',
+	activated boolean not null default false,
+	status varchar(20) not null default 'active',
+	deleted_at timestamp,
+	currency varchar(3) not null default ''
+);
+
+alter table account add column if not exists role varchar(20) not null default 'user';
+create unique index if not exists account_number_key on account (number);
+alter table account add column if not exists type varchar(20) not null default 'checking';
+alter table account add column if not exists min_balance bigint not null default 0;
+alter table account add column if not exists interest_rate double precision not null default 0;
+alter table account add column if not exists accepts_inbound boolean not null default true;
+alter table account add column if not exists encrypted_pin varchar(100) not null default '';
+alter table account add column if not exists email varchar(254) not null default '';
+-- Accounts created before activation existed count as activated
+alter table account add column if not exists activated boolean not null default true;
+create unique index if not exists account_email_key on account (email) where email <> '';
+alter table account add column if not exists status varchar(20) not null default 'active';
+alter table account add column if not exists deleted_at timestamp;
+-- Accounts created before currencies were stored hold the configured default currency
+alter table account add column if not exists currency varchar(3) not null default '';
@@ -0,0 +1,25 @@
+-- Transactions and the sequence numbering their references
+create table if not exists transactions (
+	id serial primary key,
+	reference varchar(40) not null,
+	from_account integer,
+	to_account integer,
+	amount bigint not null,
+	kind varchar(20) not null,
+	created_at timestamp not null,
+	memo varchar(500) not null default '',
+	private_note varchar(500) not null default '',
+	hash varchar(64) not null default '',
+	from_prev_hash varchar(64) not null default '',
+	to_prev_hash varchar(64) not null default ''
+);
+
+alter table transactions add column if not exists memo varchar(500) not null default '';
+alter table transactions add column if not exists private_note varchar(500) not null default '';
+alter table transactions add column if not exists hash varchar(64) not null default '';
+alter table transactions add column if not exists from_prev_hash varchar(64) not null default '';
+alter table transactions add column if not exists to_prev_hash varchar(64) not null default '';
+alter table transactions add column if not exists flagged boolean not null default false;
+create unique index if not exists transactions_reference_key on transactions (reference);
+create sequence if not exists transaction_reference_seq;
+create index if not exists transactions_from_to_idx on transactions (from_account, to_account, created_at);
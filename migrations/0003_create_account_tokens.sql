@@ -0,0 +1,11 @@
+-- Hashed single-use tokens emailed to account holders
+create table if not exists account_tokens (
+	id serial primary key,
+	account_id integer not null references account (id) on delete cascade,
+	purpose varchar(20) not null,
+	token_hash varchar(64) not null,
+	expires_at timestamp not null,
+	used_at timestamp
+);
+
+create unique index if not exists account_tokens_hash_key on account_tokens (token_hash);
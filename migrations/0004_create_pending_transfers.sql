@@ -0,0 +1,13 @@
+-- Transfers awaiting approval
+create table if not exists pending_transfers (
+	id serial primary key,
+	from_account integer not null references account (id) on delete cascade,
+	to_account bigint not null,
+	amount bigint not null,
+	memo varchar(500) not null default '',
+	private_note varchar(500) not null default '',
+	status varchar(20) not null,
+	created_at timestamp not null,
+	expires_at timestamp not null,
+	approved_by integer
+);
@@ -0,0 +1,10 @@
+-- Sensitive actions taken on accounts
+create table if not exists audit_log (
+	id serial primary key,
+	actor_id integer not null,
+	action varchar(40) not null,
+	account_id integer not null,
+	created_at timestamp not null
+);
+
+create index if not exists audit_log_action_account_idx on audit_log (action, account_id, created_at);
@@ -0,0 +1,11 @@
+-- Account numbers retired by rotations
+create table if not exists account_number_history (
+	id serial primary key,
+	account_id integer not null,
+	old_number bigint not null,
+	new_number bigint not null,
+	actor_id integer not null,
+	rotated_at timestamp not null
+);
+
+create unique index if not exists account_number_history_old_number_key on account_number_history (old_number);
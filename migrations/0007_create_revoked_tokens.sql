@@ -0,0 +1,7 @@
+-- IDs of JWT tokens revoked before their expiry, e.g. on logout; entries are useless once the token expired
+create table if not exists revoked_tokens (
+	jti varchar(64) primary key,
+	expires_at timestamp not null
+);
+
+create index if not exists revoked_tokens_expires_at_idx on revoked_tokens (expires_at);
@@ -0,0 +1,2 @@
+-- URL notified whenever the balance of the account changes, empty for none
+alter table account add column if not exists webhook_url varchar(2048) not null default '';
@@ -0,0 +1,5 @@
+-- Account numbers are assigned by the server and may have up to 18 digits, more than the original
+-- serial column holds; the sequence it was created with was never used
+alter table account alter column number drop default;
+drop sequence if exists account_number_seq;
+alter table account alter column number type bigint;
@@ -0,0 +1,7 @@
+-- Balances are int64 minor units like transaction amounts, more than the original serial column
+-- holds; the sequence it was created with was never used
+alter table account alter column balance drop default;
+drop sequence if exists account_balance_seq;
+alter table account alter column balance type bigint;
+alter table account alter column balance set default 0;
+alter table account alter column balance set not null;
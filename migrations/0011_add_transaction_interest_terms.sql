@@ -0,0 +1,3 @@
+-- Yearly rate and length in seconds of the period interest transactions were accrued for, zero for other kinds
+alter table transactions add column if not exists rate double precision not null default 0;
+alter table transactions add column if not exists period_seconds bigint not null default 0;
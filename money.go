@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units of its currency, e.g. cents, so that arithmetic on it is exact
+type Money int64
+
+// minorPerMajor is the number of minor units per major unit assumed by the float conversions, e.g. cents per dollar
+const minorPerMajor = 100
+
+// FromFloat converts an amount in major units, e.g. 12.34, to minor units, rounding to the nearest one
+func FromFloat(f float64) Money {
+	return Money(math.Round(f * minorPerMajor))
+}
+
+// ToFloat converts the amount to major units, for reporting only; do the arithmetic on Money
+func (m Money) ToFloat() float64 {
+	return float64(m) / minorPerMajor
+}
+
+// String formats the amount in major units with two decimals, e.g. "-12.34"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/minorPerMajor, m%minorPerMajor)
+}
+
+// currency describes how amounts of an ISO 4217 currency are displayed
+type currency struct {
+	Symbol string // Currency symbol, e.g. $
+	Scale  int    // Number of minor unit digits, e.g. 2 for cents
+}
+
+// currencies lists the supported currencies by ISO 4217 code
+var currencies = map[string]currency{
+	"USD": {Symbol: "$", Scale: 2},
+	"EUR": {Symbol: "€", Scale: 2},
+	"GBP": {Symbol: "£", Scale: 2},
+	"INR": {Symbol: "₹", Scale: 2},
+	"JPY": {Symbol: "¥", Scale: 0},
+}
+
+// locale describes the number formatting conventions of a display locale
+type locale struct {
+	Group       string // Thousands separator
+	Decimal     string // Decimal separator
+	SymbolAfter bool   // Whether the currency symbol follows the amount
+}
+
+// locales lists the supported display locales
+var locales = map[string]locale{
+	"en-US": {Group: ",", Decimal: "."},
+	"en-GB": {Group: ",", Decimal: "."},
+	"de-DE": {Group: ".", Decimal: ",", SymbolAfter: true},
+	"fr-FR": {Group: " ", Decimal: ",", SymbolAfter: true},
+}
+
+// parseCurrency validates an ISO 4217 currency code, e.g. "inr", falling back to def when it is empty
+// Only the supported currencies are accepted
+func parseCurrency(code, def string) (string, error) {
+	if code == "" {
+		return def, nil
+	}
+	code = strings.ToUpper(code)
+	if _, ok := currencies[code]; !ok {
+		return "", validationErrorf("unsupported currency %q", code)
+	}
+	return code, nil
+}
+
+// formatMoney formats an amount given in minor units for display, e.g. 123456 USD in en-US is "$1,234.56"
+func formatMoney(amount Money, currencyCode, localeName string) string {
+	cur, ok := currencies[currencyCode]
+	if !ok {
+		return fmt.Sprintf("%d %s", amount, currencyCode)
+	}
+	loc, ok := locales[localeName]
+	if !ok {
+		loc = locales["en-US"]
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	// Split the amount into its major and minor units
+	unit := Money(pow10(cur.Scale))
+	number := groupThousands(int64(amount/unit), loc.Group)
+	if cur.Scale > 0 {
+		number += loc.Decimal + fmt.Sprintf("%0*d", cur.Scale, amount%unit)
+	}
+
+	if loc.SymbolAfter {
+		return sign + number + " " + cur.Symbol
+	}
+	return sign + cur.Symbol + number
+}
+
+// parseMoney parses a decimal amount in major units, e.g. "12.34" USD, into minor units
+// Inputs with more decimal places than maxDecimals, capped at the currency scale, are rejected
+// instead of silently truncated
+func parseMoney(input, currencyCode string, maxDecimals int) (Money, error) {
+	cur, ok := currencies[currencyCode]
+	if !ok {
+		return 0, validationErrorf("unsupported currency %s", currencyCode)
+	}
+	if maxDecimals < 0 || maxDecimals > cur.Scale {
+		maxDecimals = cur.Scale
+	}
+
+	input = strings.TrimSpace(input)
+	sign := int64(1)
+	if strings.HasPrefix(input, "-") {
+		sign = -1
+		input = input[1:]
+	}
+
+	major, minor, _ := strings.Cut(input, ".")
+	if major == "" || !isDigits(major) || !isDigits(minor) || len(major) > 15 {
+		return 0, validationErrorf("invalid amount %q", input)
+	}
+	if len(minor) > maxDecimals {
+		return 0, validationErrorf("invalid amount %q: too many decimal places, %s allows %d", input, currencyCode, maxDecimals)
+	}
+
+	amount, err := strconv.ParseInt(major+minor+strings.Repeat("0", cur.Scale-len(minor)), 10, 64)
+	if err != nil {
+		return 0, validationErrorf("invalid amount %q", input)
+	}
+	return Money(sign * amount), nil
+}
+
+// isDigits reports whether s consists of ASCII digits only
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// groupThousands formats n with sep between every group of three digits
+func groupThousands(n int64, sep string) string {
+	digits := fmt.Sprintf("%d", n)
+	groups := []string{}
+	for len(digits) > 3 {
+		groups = append([]string{digits[len(digits)-3:]}, groups...)
+		digits = digits[:len(digits)-3]
+	}
+	groups = append([]string{digits}, groups...)
+	return strings.Join(groups, sep)
+}
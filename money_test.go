@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMoneyConversions tests that amounts convert between minor and major units without float rounding errors
+func TestMoneyConversions(t *testing.T) {
+	assert.Equal(t, Money(1234), FromFloat(12.34))
+	assert.Equal(t, Money(30), FromFloat(0.1+0.2))
+	assert.Equal(t, Money(-1999), FromFloat(-19.99))
+	assert.Equal(t, 12.34, Money(1234).ToFloat())
+
+	assert.Equal(t, "12.34", Money(1234).String())
+	assert.Equal(t, "0.05", Money(5).String())
+	assert.Equal(t, "-1.50", Money(-150).String())
+}
+
+// TestFormatMoney tests that amounts are displayed with the currency scale and locale conventions
+func TestFormatMoney(t *testing.T) {
+	assert.Equal(t, "$1,234.56", formatMoney(123456, "USD", "en-US"))
+	assert.Equal(t, "-$0.05", formatMoney(-5, "USD", "en-US"))
+	assert.Equal(t, "1.234,56 €", formatMoney(123456, "EUR", "de-DE"))
+	assert.Equal(t, "¥123,456", formatMoney(123456, "JPY", "en-US"))
+	assert.Equal(t, "1 000 000,00 €", formatMoney(100000000, "EUR", "fr-FR"))
+}
+
+// TestAccountBalanceDisplay tests that account responses carry the formatted balance
+func TestAccountBalanceDisplay(t *testing.T) {
+	config := newTestConfig(t)
+	config.DefaultCurrency = "EUR"
+	config.DisplayLocale = "de-DE"
+	server, store := newTestServer(config)
+	acc, token := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 250075))
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, Money(250075), resp.Balance)
+	assert.Equal(t, "2.500,75 €", resp.BalanceDisplay)
+}
+
+// TestParseMoneyPrecision tests that decimal amounts with more places than the currency allows are rejected
+func TestParseMoneyPrecision(t *testing.T) {
+	amount, err := parseMoney("12.34", "USD", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, Money(1234), amount)
+
+	amount, err = parseMoney("12.3", "USD", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, Money(1230), amount)
+
+	_, err = parseMoney("12.345", "USD", -1)
+	assert.ErrorContains(t, err, "too many decimal places")
+
+	// The configured maximum can be stricter than the currency
+	_, err = parseMoney("12.3", "USD", 0)
+	assert.ErrorContains(t, err, "too many decimal places")
+	_, err = parseMoney("12.5", "JPY", -1)
+	assert.ErrorContains(t, err, "too many decimal places")
+
+	for _, input := range []string{"", ".5", "1e3", "12.3.4", "abc"} {
+		_, err = parseMoney(input, "USD", -1)
+		assert.NotNil(t, err, input)
+	}
+}
+
+// TestTransferDecimalAmount tests that a transfer accepts a decimal amount with the currency precision
+func TestTransferDecimalAmount(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 10000))
+
+	transfer := func(amount string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"toAccount":%d,"amountDecimal":%q}`, recipient.Number, amount)
+		req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec := transfer("12.345")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too many decimal places")
+
+	rec = transfer("12.34")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	recipient, _ = store.GetAccountByID(context.Background(), recipient.ID)
+	assert.Equal(t, Money(1234), recipient.Balance)
+}
+
+// TestAccountCurrency tests that accounts are opened in a supported currency and never transfer across currencies
+func TestAccountCurrency(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 10000))
+
+	create := func(currency string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"firstName":"a","lastName":"b","password":"hunter88888","currency":%q}`, currency)
+		return serve(server, httptest.NewRequest("POST", "/account", strings.NewReader(body)))
+	}
+
+	rec := create("XYZ")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `unsupported currency \"XYZ\"`)
+
+	rec = create("inr")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	inr := new(Account)
+	decodeData(t, rec.Body, inr)
+	assert.Equal(t, "INR", inr.Currency)
+	assert.Equal(t, "₹0.00", inr.BalanceDisplay)
+
+	// Without a currency the configured default is used
+	rec = create("")
+	usd := new(Account)
+	decodeData(t, rec.Body, usd)
+	assert.Equal(t, "USD", usd.Currency)
+
+	rec = serve(server, transferRequest(token, inr.Number, 100))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "currency_mismatch")
+
+	rec = serve(server, transferRequest(token, usd.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"currency":"USD"`)
+}
+
+// TestTransactionAmountDisplay tests that transfer receipts, transaction lists and statements carry amounts
+// formatted with the account currency scale and the display locale
+func TestTransactionAmountDisplay(t *testing.T) {
+	config := newTestConfig(t)
+	config.DefaultCurrency = "JPY"
+	config.DisplayLocale = "de-DE"
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 200000))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 12345))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	receipt := new(TransferReceipt)
+	decodeData(t, rec.Body, receipt)
+	assert.Equal(t, "12.345 ¥", receipt.AmountDisplay)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/%s", sender.ID, path), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	rec = get("transactions")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	transactions := []*Transaction{}
+	decodeData(t, rec.Body, &transactions)
+	if assert.Len(t, transactions, 1) {
+		assert.Equal(t, "12.345 ¥", transactions[0].AmountDisplay)
+	}
+
+	// Statement lines show debits as negative amounts
+	rec = get("statement")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	statement := new(Statement)
+	decodeData(t, rec.Body, statement)
+	if assert.Len(t, statement.Lines, 1) {
+		assert.Equal(t, "-12.345 ¥", statement.Lines[0].AmountDisplay)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AccountNumberPolicy describes the format of account numbers
+type AccountNumberPolicy struct {
+	Digits    int           // Number of digits of every account number
+	Luhn      bool          // Whether the last digit is a Luhn check digit
+	Blacklist []NumberRange // Numbers that are never assigned to an account
+}
+
+// maxNumberAttempts bounds the random draws made for a single new account number
+const maxNumberAttempts = 100
+
+// accountNumberKey is the unique index guarding against two accounts with the same number
+const accountNumberKey = "account_number_key"
+
+// accountEmailKey is the unique index guarding against two accounts with the same email address
+const accountEmailKey = "account_email_key"
+
+// maxCreateAttempts bounds how often account creation picks a new number after losing it to a concurrent one
+const maxCreateAttempts = 3
+
+// NumberRange is an inclusive range of account numbers
+type NumberRange struct {
+	From int64
+	To   int64
+}
+
+// Generate returns a random account number of the configured length that is not blacklisted
+func (p AccountNumberPolicy) Generate() int64 {
+	for {
+		if number := p.generate(); !p.Blacklisted(number) {
+			return number
+		}
+	}
+}
+
+// Assign returns a generated account number that no stored account uses yet
+// The unique index on the number still guards against a concurrent assignment of the same number
+func (p AccountNumberPolicy) Assign(ctx context.Context, store Storage) (int64, error) {
+	for i := 0; i < maxNumberAttempts; i++ {
+		number := p.Generate()
+		exists, err := store.AccountNumberExists(ctx, number)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return number, nil
+		}
+	}
+	return 0, fmt.Errorf("no unused account number found after %d attempts", maxNumberAttempts)
+}
+
+// generate returns a random account number of the configured length
+func (p AccountNumberPolicy) generate() int64 {
+	if !p.Luhn {
+		low := pow10(p.Digits - 1)
+		return low + randInt63n(pow10(p.Digits)-low)
+	}
+
+	// Reserve the last digit for the check digit
+	low := pow10(p.Digits - 2)
+	payload := low + randInt63n(pow10(p.Digits-1)-low)
+	return payload*10 + luhnCheckDigit(payload)
+}
+
+// randInt63n returns a uniformly distributed number in [0, n) from the operating system's secure random source
+func randInt63n(n int64) int64 {
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		// The system random source failing leaves no safe way to number accounts
+		panic(fmt.Sprintf("reading random account number: %v", err))
+	}
+	return v.Int64()
+}
+
+// Validate checks that a number given by a client can be an account number, so malformed
+// input is rejected before it reaches the database
+func (p AccountNumberPolicy) Validate(number int64) error {
+	if number < pow10(p.Digits-1) || number >= pow10(p.Digits) {
+		return validationErrorf("invalid account number %d: must have %d digits", number, p.Digits)
+	}
+	if p.Luhn && luhnCheckDigit(number/10) != number%10 {
+		return validationErrorf("invalid account number %d: check digit mismatch", number)
+	}
+	return nil
+}
+
+// Blacklisted reports whether the number may never be assigned to an account
+func (p AccountNumberPolicy) Blacklisted(number int64) bool {
+	for _, r := range p.Blacklist {
+		if number >= r.From && number <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// exhausted reports whether the blacklist covers every number of the configured length,
+// in which case Generate could never return
+func (p AccountNumberPolicy) exhausted() bool {
+	ranges := append([]NumberRange{}, p.Blacklist...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+
+	// Walk the sorted ranges looking for a gap in [low, high]
+	low, high := pow10(p.Digits-1), pow10(p.Digits)-1
+	next := low
+	for _, r := range ranges {
+		if r.From > next {
+			break
+		}
+		if r.To >= next {
+			next = r.To + 1
+		}
+	}
+	return next > high
+}
+
+// ValidateAssignable checks that a number chosen for a new account is well-formed and not blacklisted
+func (p AccountNumberPolicy) ValidateAssignable(number int64) error {
+	if err := p.Validate(number); err != nil {
+		return err
+	}
+	if p.Blacklisted(number) {
+		return validationErrorf("account number %d is reserved", number)
+	}
+	return nil
+}
+
+// parseNumberRanges parses a comma separated list of account numbers and "from-to" ranges
+func parseNumberRanges(spec string) ([]NumberRange, error) {
+	ranges := []NumberRange{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			to = from
+		}
+		r := NumberRange{}
+		var err error
+		if r.From, err = strconv.ParseInt(strings.TrimSpace(from), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid account number range %q", entry)
+		}
+		if r.To, err = strconv.ParseInt(strings.TrimSpace(to), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid account number range %q", entry)
+		}
+		if r.From > r.To {
+			return nil, fmt.Errorf("invalid account number range %q: start is after end", entry)
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// luhnCheckDigit computes the Luhn check digit to append to payload
+func luhnCheckDigit(payload int64) int64 {
+	sum := int64(0)
+	double := true
+	for ; payload > 0; payload /= 10 {
+		digit := payload % 10
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// pow10 returns 10 to the power of n
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGeneratedNumbersMatchPolicy tests that generated numbers have the configured length and check digit
+func TestGeneratedNumbersMatchPolicy(t *testing.T) {
+	for _, policy := range []AccountNumberPolicy{{Digits: 6}, {Digits: 10, Luhn: true}} {
+		for i := 0; i < 1000; i++ {
+			number := policy.Generate()
+			assert.Nil(t, policy.Validate(number))
+			assert.True(t, number >= pow10(policy.Digits-1))
+		}
+	}
+
+	// 79927398713 is the canonical Luhn example
+	assert.Equal(t, int64(3), luhnCheckDigit(7992739871))
+
+	// Numbers must have exactly the configured number of digits and a matching check digit
+	policy := AccountNumberPolicy{Digits: 6}
+	assert.EqualError(t, policy.Validate(12), "invalid account number 12: must have 6 digits")
+	assert.EqualError(t, policy.Validate(1234567), "invalid account number 1234567: must have 6 digits")
+	assert.Nil(t, policy.Validate(123456))
+	policy = AccountNumberPolicy{Digits: 11, Luhn: true}
+	assert.Nil(t, policy.Validate(79927398713))
+	assert.EqualError(t, policy.Validate(79927398714), "invalid account number 79927398714: check digit mismatch")
+}
+
+// TestGenerateSkipsBlacklist tests that generation never yields a blacklisted number
+func TestGenerateSkipsBlacklist(t *testing.T) {
+	ranges, err := parseNumberRanges("10-89, 95")
+	assert.Nil(t, err)
+	policy := AccountNumberPolicy{Digits: 2, Blacklist: ranges}
+
+	for i := 0; i < 1000; i++ {
+		number := policy.Generate()
+		assert.Contains(t, []int64{90, 91, 92, 93, 94, 96, 97, 98, 99}, number)
+	}
+
+	// Blacklisted numbers can't be chosen for an account either
+	assert.EqualError(t, policy.ValidateAssignable(95), "account number 95 is reserved")
+	assert.Nil(t, policy.ValidateAssignable(96))
+
+	// A blacklist covering every number is caught
+	policy.Blacklist = append(policy.Blacklist, NumberRange{From: 90, To: 99})
+	assert.True(t, policy.exhausted())
+}
+
+// TestAssignedNumbersAreDistinct tests that many assigned numbers never collide with stored accounts
+func TestAssignedNumbersAreDistinct(t *testing.T) {
+	store := newMemStore()
+	policy := AccountNumberPolicy{Digits: 3}
+
+	seen := map[int64]bool{}
+	for i := 0; i < 300; i++ {
+		number, err := policy.Assign(context.Background(), store)
+		assert.Nil(t, err)
+		assert.False(t, seen[number], "number %d assigned twice", number)
+		seen[number] = true
+		assert.Nil(t, store.CreateAccount(context.Background(), &Account{Number: number}))
+	}
+
+	// Once every number is taken assignment fails instead of looping forever
+	policy = AccountNumberPolicy{Digits: 1}
+	for number := int64(1); number <= 9; number++ {
+		assert.Nil(t, store.CreateAccount(context.Background(), &Account{Number: number}))
+	}
+	_, err := policy.Assign(context.Background(), store)
+	assert.ErrorContains(t, err, "no unused account number")
+}
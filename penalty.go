@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ways of enforcing the login penalty
+const (
+	PenaltyDelay  = "delay"  // Hold the response to a failed login for the penalty
+	PenaltyReject = "reject" // Reject further logins with 429 until the penalty is served
+)
+
+// penalty imposes an exponentially growing penalty per key on consecutive failures, e.g. 1s, 2s, 4s, up to a cap
+type penalty struct {
+	mu         sync.Mutex
+	base       time.Duration    // Penalty of the first failure, 0 disables penalties
+	max        time.Duration    // Largest penalty imposed
+	window     time.Duration    // Failures older than this are forgotten
+	now        func() time.Time // Clock, replaceable in tests
+	maxEntries int              // Most keys tracked at once
+	entries    map[string]*penaltyEntry
+}
+
+// penaltyEntry tracks the failures of a single key
+type penaltyEntry struct {
+	failures    int
+	lastFailure time.Time
+	until       time.Time // End of the current penalty
+}
+
+// newPenalty creates a penalty starting at base and doubling on every consecutive failure up to max
+func newPenalty(base, max, window time.Duration) *penalty {
+	return &penalty{
+		base:       base,
+		max:        max,
+		window:     window,
+		now:        time.Now,
+		maxEntries: maxTrackedKeys,
+		entries:    map[string]*penaltyEntry{},
+	}
+}
+
+// expired reports whether the entry's penalty is served and its failures are past the window
+func (p *penalty) expired(entry *penaltyEntry, now time.Time) bool {
+	return !now.Before(entry.until) && now.Sub(entry.lastFailure) > p.window
+}
+
+// evict makes room for a new key, dropping the expired entries and, should that not be enough,
+// the one that failed longest ago; the caller must hold the lock
+func (p *penalty) evict(now time.Time) {
+	for key, entry := range p.entries {
+		if p.expired(entry, now) {
+			delete(p.entries, key)
+		}
+	}
+	if len(p.entries) < p.maxEntries {
+		return
+	}
+
+	oldest := ""
+	for key, entry := range p.entries {
+		if oldest == "" || entry.lastFailure.Before(p.entries[oldest].lastFailure) {
+			oldest = key
+		}
+	}
+	delete(p.entries, oldest)
+}
+
+// Remaining returns how much longer the key is penalized, 0 when it isn't
+func (p *penalty) Remaining(key string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[key]
+	if !ok {
+		return 0
+	}
+	now := p.now()
+	if p.expired(entry, now) {
+		delete(p.entries, key)
+		return 0
+	}
+	if remaining := entry.until.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Fail records a failure for the key and returns the penalty it incurs
+func (p *penalty) Fail(key string) time.Duration {
+	if p.base <= 0 {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	entry, ok := p.entries[key]
+	if !ok || now.Sub(entry.lastFailure) > p.window {
+		delete(p.entries, key)
+		if len(p.entries) >= p.maxEntries {
+			p.evict(now)
+		}
+		entry = &penaltyEntry{}
+		p.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	// Double the penalty for every earlier failure, stopping at the cap before it can overflow
+	delay := p.base
+	for i := 1; i < entry.failures && delay < p.max; i++ {
+		delay *= 2
+	}
+	if delay > p.max {
+		delay = p.max
+	}
+
+	entry.until = now.Add(delay)
+	return delay
+}
+
+// Reset forgets the failures of the key
+func (p *penalty) Reset(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.entries, key)
+}
+
+// sleepContext waits for d, returning early when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPenaltyForgetsExpiredKeys tests that keys are forgotten once their penalty window has passed,
+// and that the number of tracked keys stays capped
+func TestPenaltyForgetsExpiredKeys(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	p := newPenalty(time.Second, time.Minute, time.Hour)
+	p.now = func() time.Time { return now }
+	p.maxEntries = 3
+
+	p.Fail("a")
+	now = now.Add(time.Hour + time.Second)
+	assert.Zero(t, p.Remaining("a"))
+	assert.NotContains(t, p.entries, "a")
+
+	// New keys push out the oldest once the cap is reached
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		p.Fail("login-" + strconv.Itoa(i))
+	}
+	assert.Len(t, p.entries, 3)
+	assert.Contains(t, p.entries, "login-9")
+	assert.Equal(t, time.Second, p.Remaining("login-9"))
+}
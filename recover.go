@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverMiddleware turns a panicking handler into a 500 response instead of a dropped connection,
+// logging the panic and stack with the request ID
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// The server uses this panic to abort a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logRequestf(r, "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			WriteError(w, http.StatusInternalServerError, ApiError{Message: "internal server error"})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRecoverMiddleware tests that a panicking handler gets a 500 JSON error logged with the request ID
+func TestRecoverMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	handler := requestIDMiddleware(false)(recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest("GET", "/account", nil)
+	req.Header.Set("X-Request-ID", "upstream-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "internal server error", decodeError(t, rec.Body).Message)
+	assert.Contains(t, out.String(), "panic serving GET /account: boom")
+	assert.Contains(t, out.String(), "[request upstream-42]")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// referenceAlphabet holds the characters used for reference suffixes, leaving out look-alikes such as 0/O and 1/I
+const referenceAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newTransactionReference builds the human-shareable reference of a transaction, e.g. "TXN-2024-0001-ABCD"
+// The reference is derived from the transaction's year and sequence number, so it is deterministic and
+// unique as long as the sequence is; the suffix acts as a checksum that catches mistyped references
+func newTransactionReference(prefix string, createdAt time.Time, seq int64) string {
+	year := createdAt.UTC().Year()
+	base := fmt.Sprintf("%s-%d-%04d", prefix, year, seq)
+
+	// Derive the 4 character suffix from a hash of the base reference
+	sum := sha256.Sum256([]byte(base))
+	suffix := make([]byte, 4)
+	for i := range suffix {
+		suffix[i] = referenceAlphabet[int(sum[i])%len(referenceAlphabet)]
+	}
+
+	return base + "-" + string(suffix)
+}
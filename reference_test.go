@@ -0,0 +1,30 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTransactionReferencesUnique tests that references are unique and follow the expected format
+func TestTransactionReferencesUnique(t *testing.T) {
+	format := regexp.MustCompile(`^TXN-\d{4}-\d{4,}-[A-HJ-NP-Z2-9]{4}$`)
+	seen := map[string]bool{}
+
+	// Generate references across a year boundary
+	for _, year := range []int{2024, 2025} {
+		createdAt := time.Date(year, 6, 1, 0, 0, 0, 0, time.UTC)
+		for seq := int64(1); seq <= 20000; seq++ {
+			ref := newTransactionReference("TXN", createdAt, seq)
+			assert.Regexp(t, format, ref)
+			assert.False(t, seen[ref], "duplicate reference %s", ref)
+			seen[ref] = true
+		}
+	}
+
+	// The same transaction always gets the same reference
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, newTransactionReference("TXN", createdAt, 1), newTransactionReference("TXN", createdAt, 1))
+}
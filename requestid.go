@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// requestIDHeader carries the request ID between clients, upstreams and this service
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// requestIDMiddleware assigns every request an ID, reusing the one sent by the client when present,
+// stores it in the request context and echoes it back in the response
+// In strict mode requests without a valid ID are rejected instead
+func requestIDMiddleware(strict bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if strict && id == "" {
+				WriteError(w, http.StatusBadRequest, ApiError{Message: "missing " + requestIDHeader + " header"})
+				return
+			}
+			if strict && !validRequestID(id) {
+				WriteError(w, http.StatusBadRequest, ApiError{Message: "invalid " + requestIDHeader + " header"})
+				return
+			}
+			// The ID ends up in the logs, so one that could forge log lines is replaced
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(requestIDHeader, id)
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestIDFromContext returns the ID of the request the context belongs to
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether a client supplied request ID is short and printable ASCII without spaces
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequestf logs a message about a request, tagged with its ID so it can be matched with the request log
+// and the client's error reports
+func logRequestf(r *http.Request, format string, args ...any) {
+	log.Printf(format+" [request %s]", append(args, requestIDFromContext(r.Context()))...)
+}
+
+// newRequestID generates a random version 4 UUID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
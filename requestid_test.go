@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStrictRequestID tests that strict mode rejects requests without a request ID
+func TestStrictRequestID(t *testing.T) {
+	config := newTestConfig(t)
+	config.RequireRequestID = true
+	server, _ := newTestServer(config)
+
+	rec := serve(server, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "X-Request-ID")
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("X-Request-ID", "upstream-42")
+	rec = serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "upstream-42", rec.Header().Get("X-Request-ID"))
+}
+
+// TestGeneratedRequestID tests that the default mode generates a request ID when none is sent
+func TestGeneratedRequestID(t *testing.T) {
+	server, _ := newTestServer(newTestConfig(t))
+
+	rec := serve(server, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	assert.Regexp(t, uuid, rec.Header().Get("X-Request-ID"))
+}
+
+// TestRequestIDInLogs tests that handler log lines carry the request ID and that unsafe IDs are replaced
+func TestRequestIDInLogs(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	config := newTestConfig(t)
+	config.LogLevel = LogLevelDebug
+	server, _ := newTestServer(config)
+
+	req := httptest.NewRequest("GET", "/account/1", nil)
+	req.Header.Set("X-Request-ID", "upstream-42")
+	serve(server, req)
+	assert.Contains(t, out.String(), "debug: authenticating GET /account/1 [request upstream-42]")
+
+	// An ID that could forge log lines is replaced, or rejected in strict mode
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("X-Request-ID", "x\nforged")
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, "x\nforged", rec.Header().Get("X-Request-ID"))
+
+	config.RequireRequestID = true
+	strict, _ := newTestServer(config)
+	rec = serve(strict, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
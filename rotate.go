@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleRotateNumber lets an admin reissue the account number of an account, e.g. after fraud
+// The account keeps its ID, balance and transactions; the old number is retired for good, which also
+// invalidates every token issued for it
+func (s *APIServer) handleRotateNumber(w http.ResponseWriter, r *http.Request) error {
+	admin, err := authenticatedAccount(r, s.store, s.config)
+	if err != nil || !admin.IsAdmin() {
+		permissionDenied(w, err)
+		return nil
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	var account *Account
+	err = s.store.WithTx(r.Context(), func(tx Storage) error {
+		accounts, err := tx.LockAccounts(r.Context(), []int{id})
+		if err != nil {
+			return err
+		}
+		if len(accounts) == 0 {
+			return fmt.Errorf("account %d %w", id, errAccountNotFound)
+		}
+		account = accounts[0]
+
+		number, err := s.config.AccountNumbers.Assign(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateAccountNumber(r.Context(), id, number); err != nil {
+			return err
+		}
+
+		// Keep the old number for reference, and so that it is never assigned again
+		now := s.now().UTC()
+		change := &AccountNumberChange{
+			AccountID: id,
+			OldNumber: account.Number,
+			NewNumber: number,
+			ActorID:   admin.ID,
+			RotatedAt: now,
+		}
+		if err := tx.CreateAccountNumberChange(r.Context(), change); err != nil {
+			return err
+		}
+		account.Number = number
+
+		return tx.CreateAuditEntry(r.Context(), &AuditEntry{ActorID: admin.ID, Action: AuditNumberRotation, AccountID: id, CreatedAt: now})
+	})
+	if err != nil {
+		return err
+	}
+
+	logRequestf(r, "account %d renumbered by account %d", id, admin.ID)
+
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRotateNumber tests that rotation reissues the number, keeps the history and rejects tokens of the old number
+func TestRotateNumber(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	_, adminToken := newTestAccount(t, store, RoleAdmin)
+	oldNumber := acc.Number
+
+	rotate := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/admin/account/%d/rotate-number", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	getAccount := func(token string) int {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req).Code
+	}
+
+	deposit := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/deposit", acc.ID), strings.NewReader(`{"amount":500}`))
+	deposit.Header.Set("x-jwt-token", token)
+	assert.Equal(t, http.StatusOK, serve(server, deposit).Code)
+
+	// Account holders can't rotate their own number
+	assert.Equal(t, http.StatusForbidden, rotate(token).Code)
+
+	rec := rotate(adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(Account)
+	decodeData(t, rec.Body, resp)
+	assert.Equal(t, acc.ID, resp.ID)
+	assert.NotEqual(t, oldNumber, resp.Number)
+
+	// The account and its history are preserved under the new number
+	rotated, err := store.GetAccountByNumber(context.Background(), int(resp.Number))
+	assert.Nil(t, err)
+	assert.Equal(t, Money(500), rotated.Balance)
+	transactions, err := store.GetTransactionsByAccount(context.Background(), acc.ID, 0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, transactions, 1)
+
+	// The old number is recorded and never handed out again
+	assert.Len(t, store.numberChanges, 1)
+	assert.Equal(t, oldNumber, store.numberChanges[0].OldNumber)
+	exists, err := store.AccountNumberExists(context.Background(), oldNumber)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, AuditNumberRotation, store.audit[0].Action)
+
+	// Tokens issued for the old number are rejected, new ones work
+	assert.Equal(t, http.StatusForbidden, getAccount(token))
+	body := `{"number":` + strconv.FormatInt(resp.Number, 10) + `,"password":"hunter88888"}`
+	rec = serve(server, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	login := new(LoginResponse)
+	decodeData(t, rec.Body, login)
+	assert.Equal(t, http.StatusOK, getAccount(login.Token))
+}
@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// flightGroup deduplicates concurrent calls sharing a key, so only the first runs and the others
+// wait for and share its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a call in progress
+type flightCall struct {
+	wg   sync.WaitGroup
+	dups int // Number of callers waiting on this call
+	val  any
+	err  error
+}
+
+// Do runs fn once for all concurrent callers with the same key and returns its result to each of them,
+// reporting whether the result was shared with other callers
+func (g *flightGroup) Do(key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	shared = c.dups > 0
+	g.mu.Unlock()
+
+	return c.val, c.err, shared
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Statement formats
+const (
+	StatementJSON = "json"
+	StatementCSV  = "csv"
+	StatementPDF  = "pdf"
+)
+
+// defaultStatementPeriod is the period covered by a statement whose start isn't given
+const defaultStatementPeriod = 30 * 24 * time.Hour
+
+// handleGetStatement sends the statement of an account over a period, e.g.
+// GET /account/1/statement?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&format=csv
+// The period includes from but not to and defaults to the last 30 days; the format defaults to JSON
+// A PDF statement is a printable document, e.g. for visa or loan applications
+func (s *APIServer) handleGetStatement(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = StatementJSON
+	}
+	if format != StatementJSON && format != StatementCSV && format != StatementPDF {
+		return validationErrorf("invalid format given %s, expected %s, %s or %s", format, StatementJSON, StatementCSV, StatementPDF)
+	}
+
+	from, to, err := parseStatementPeriod(r, s.now().UTC())
+	if err != nil {
+		return err
+	}
+
+	statement, err := s.buildStatement(r.Context(), id, from, to)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case StatementCSV:
+		return writeStatementCSV(w, statement)
+	case StatementPDF:
+		return writeStatementPDF(w, statement)
+	}
+	return WriteJSON(w, http.StatusOK, statement)
+}
+
+// parseStatementPeriod reads the from and to query parameters of a statement request
+func parseStatementPeriod(r *http.Request, now time.Time) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	to = now
+	if str := query.Get("to"); str != "" {
+		if to, err = time.Parse(time.RFC3339, str); err != nil {
+			return from, to, validationErrorf("invalid to given %s, expected an RFC 3339 timestamp", str)
+		}
+	}
+	from = to.Add(-defaultStatementPeriod)
+	if str := query.Get("from"); str != "" {
+		if from, err = time.Parse(time.RFC3339, str); err != nil {
+			return from, to, validationErrorf("invalid from given %s, expected an RFC 3339 timestamp", str)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, validationErrorf("from must be earlier than to")
+	}
+
+	// Transaction times are stored in UTC
+	return from.UTC(), to.UTC(), nil
+}
+
+// buildStatement gathers the transactions of the account over the period and works out the balances
+// The opening balance is the current one minus everything that happened since the start of the period,
+// so the account is locked while reading to keep its balance and transactions consistent
+func (s *APIServer) buildStatement(ctx context.Context, id int, from, to time.Time) (*Statement, error) {
+	var account *Account
+	var transactions []*Transaction
+	counterparties := map[int]string{}
+	err := s.store.WithTx(ctx, func(tx Storage) error {
+		accounts, err := tx.LockAccounts(ctx, []int{id})
+		if err != nil {
+			return err
+		}
+		if len(accounts) == 0 {
+			return fmt.Errorf("account %d %w", id, errAccountNotFound)
+		}
+		account = accounts[0]
+
+		if transactions, err = tx.GetTransactionsSince(ctx, id, from); err != nil {
+			return err
+		}
+
+		// Show the other party of each transaction by its masked account number
+		var ids []int
+		for _, t := range transactions {
+			if other := counterpartyID(t, id); other != 0 {
+				ids = append(ids, other)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		others, err := tx.GetAccountsByIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, other := range others {
+			counterparties[other.ID] = maskNumber(other.Number)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		Number:   account.Number,
+		Holder:   account.FirstName + " " + account.LastName,
+		Currency: account.Currency,
+		From:     from,
+		To:       to,
+		Lines:    []StatementLine{},
+	}
+
+	// Undo every transaction since the start of the period to find the opening balance
+	statement.OpeningBalance = account.Balance
+	for _, t := range transactions {
+		statement.OpeningBalance -= signedAmount(t, id)
+	}
+
+	balance := statement.OpeningBalance
+	for _, t := range transactions {
+		if !t.CreatedAt.Before(to) {
+			break
+		}
+		balance += signedAmount(t, id)
+		statement.Lines = append(statement.Lines, StatementLine{
+			Date:          t.CreatedAt,
+			Reference:     t.Reference,
+			Kind:          t.Kind,
+			Counterparty:  counterparties[counterpartyID(t, id)],
+			Amount:        signedAmount(t, id),
+			AmountDisplay: formatMoney(signedAmount(t, id), s.currencyOf(account), s.config.DisplayLocale),
+			Balance:       balance,
+		})
+	}
+	statement.ClosingBalance = balance
+	return statement, nil
+}
+
+// counterpartyID returns the ID of the account on the other side of the transaction, 0 when there is none
+func counterpartyID(t *Transaction, id int) int {
+	if t.FromAccount == id {
+		return t.ToAccount
+	}
+	return t.FromAccount
+}
+
+// signedAmount returns the amount of the transaction as seen by the account, negative when it was debited
+func signedAmount(t *Transaction, id int) Money {
+	if t.FromAccount == id {
+		return -t.Amount
+	}
+	return t.Amount
+}
+
+// statementFilename names the downloaded statement file, e.g. statement-100001-20240301-20240401.csv
+func statementFilename(statement *Statement, extension string) string {
+	return fmt.Sprintf("statement-%d-%s-%s.%s", statement.Number,
+		statement.From.Format("20060102"), statement.To.Format("20060102"), extension)
+}
+
+// writeStatementCSV sends the statement as a CSV attachment with a header row
+func writeStatementCSV(w http.ResponseWriter, statement *Statement) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", statementFilename(statement, StatementCSV)))
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"date", "kind", "counterparty", "amount", "balance"}); err != nil {
+		return err
+	}
+	for _, line := range statement.Lines {
+		record := []string{line.Date.Format(time.RFC3339), line.Kind, line.Counterparty, line.Amount.String(), line.Balance.String()}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// statementColumns are the headings and widths in millimetres of the transaction table of a PDF statement
+var statementColumns = []struct {
+	Heading string
+	Width   float64
+}{
+	{"Date", 40}, {"Kind", 25}, {"Counterparty", 35}, {"Amount", 35}, {"Balance", 35},
+}
+
+// writeStatementPDF sends the statement as a printable PDF attachment
+// The document is rendered in memory first, so a failure can still be reported as an error response
+func writeStatementPDF(w http.ResponseWriter, statement *Statement) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Account statement", true)
+	// The built-in fonts are not Unicode, so names are translated to their code page
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, "Account statement", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(statement.Holder), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("Account %s, amounts in %s", maskNumber(statement.Number), statement.Currency), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("Period %s to %s", statement.From.Format(time.RFC3339), statement.To.Format(time.RFC3339)), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Opening balance "+statement.OpeningBalance.String(), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	for _, column := range statementColumns {
+		pdf.CellFormat(column.Width, 7, column.Heading, "B", 0, "L", false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range statement.Lines {
+		cells := []string{line.Date.Format("2006-01-02 15:04"), line.Kind, line.Counterparty, line.Amount.String(), line.Balance.String()}
+		for i, cell := range cells {
+			align := "L"
+			if i >= 3 {
+				align = "R"
+			}
+			pdf.CellFormat(statementColumns[i].Width, 6, cell, "", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 6, "Closing balance "+statement.ClosingBalance.String(), "", 1, "L", false, 0, "")
+
+	var body bytes.Buffer
+	if err := pdf.Output(&body); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", statementFilename(statement, StatementPDF)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body.Bytes())
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetStatement tests that a statement lists the transactions of its period with running balances,
+// as JSON or as a CSV attachment
+func TestGetStatement(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	other, _ := newTestAccount(t, store, RoleUser)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 12, 0, 0, 0, time.UTC) }
+
+	// One transaction before, two within and one after the period
+	for i, tx := range []*Transaction{
+		{ToAccount: acc.ID, Amount: 1000, Kind: TransactionDeposit, CreatedAt: day(1)},
+		{FromAccount: acc.ID, ToAccount: other.ID, Amount: 200, Kind: TransactionTransfer, CreatedAt: day(3)},
+		{ToAccount: acc.ID, Amount: 700, Kind: TransactionDeposit, CreatedAt: day(5)},
+		{ToAccount: acc.ID, Amount: 300, Kind: TransactionInterest, CreatedAt: day(9)},
+	} {
+		tx.Reference = fmt.Sprintf("TXN-%d", i)
+		assert.Nil(t, store.CreateTransaction(context.Background(), tx))
+	}
+	store.accounts[acc.ID].Balance = 1800
+
+	statement := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/statement?%s", acc.ID, query), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	period := "from=2024-03-02T00:00:00Z&to=2024-03-06T00:00:00Z"
+
+	rec := statement(period)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	got := new(Statement)
+	decodeData(t, rec.Body, got)
+	assert.Equal(t, Money(1000), got.OpeningBalance)
+	assert.Equal(t, Money(1500), got.ClosingBalance)
+	if assert.Len(t, got.Lines, 2) {
+		assert.Equal(t, Money(-200), got.Lines[0].Amount)
+		assert.Equal(t, Money(800), got.Lines[0].Balance)
+		assert.Equal(t, maskNumber(other.Number), got.Lines[0].Counterparty)
+		assert.Equal(t, Money(700), got.Lines[1].Amount)
+		assert.Equal(t, Money(1500), got.Lines[1].Balance)
+		assert.Empty(t, got.Lines[1].Counterparty)
+	}
+
+	rec = statement(period + "&format=csv")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"date", "kind", "counterparty", "amount", "balance"},
+		{"2024-03-03T12:00:00Z", "transfer", maskNumber(other.Number), "-2.00", "8.00"},
+		{"2024-03-05T12:00:00Z", "deposit", "", "7.00", "15.00"},
+	}, records)
+
+	rec = statement(period + "&format=pdf")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "%PDF-"))
+
+	for _, query := range []string{"format=xml", "from=yesterday", "from=2024-03-06T00:00:00Z&to=2024-03-02T00:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, statement(query).Code, query)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkAccountStatus rejects moving money out of an account that is not in normal use
+func checkAccountStatus(acc *Account) error {
+	switch acc.Status {
+	case StatusFrozen:
+		return &TransferError{Code: "account_frozen", Message: "account is frozen, unfreeze it before moving money"}
+	case StatusClosed:
+		return &TransferError{Code: "account_closed", Message: "account is closed"}
+	}
+	return nil
+}
+
+// handleSetAccountStatus returns a handler moving the account to the given lifecycle state and audit-logging
+// the change as action, e.g. PATCH /account/1/freeze; closed accounts stay closed
+// Admins may change any account, while holders may only freeze their own, e.g. after losing their credentials,
+// so that a freeze can't be lifted by the holder
+func (s *APIServer) handleSetAccountStatus(status, action string) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		actor, err := authenticatedAccount(r, s.store, s.config)
+		if err != nil {
+			permissionDenied(w, err)
+			return nil
+		}
+		id, err := getID(r)
+		if err != nil {
+			return err
+		}
+		if !actor.IsAdmin() && (status != StatusFrozen || actor.ID != id) {
+			permissionDenied(w, nil)
+			return nil
+		}
+
+		var account *Account
+		err = s.store.WithTx(r.Context(), func(tx Storage) error {
+			accounts, err := tx.LockAccounts(r.Context(), []int{id})
+			if err != nil {
+				return err
+			}
+			if len(accounts) == 0 {
+				return fmt.Errorf("account %d %w", id, errAccountNotFound)
+			}
+			account = accounts[0]
+
+			if account.Status == StatusClosed {
+				return &TransferError{Code: "account_closed", Message: "account is closed"}
+			}
+			account.Status = status
+			if err := tx.UpdateAccountStatus(r.Context(), id, status); err != nil {
+				return err
+			}
+			return tx.CreateAuditEntry(r.Context(), &AuditEntry{ActorID: actor.ID, Action: action, AccountID: id, CreatedAt: s.now().UTC()})
+		})
+		if err != nil {
+			return err
+		}
+
+		logRequestf(r, "account %d set %s by account %d", id, status, actor.ID)
+
+		s.presentAccounts(account)
+		return WriteJSON(w, http.StatusOK, account)
+	}
+}
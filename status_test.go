@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFrozenAccountBlocksTransfersAndWithdrawals tests that a frozen account can't move money out until an admin unfreezes it
+func TestFrozenAccountBlocksTransfersAndWithdrawals(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+	recipient, recipientToken := newTestAccount(t, store, RoleUser)
+	admin, adminToken := newTestAccount(t, store, RoleAdmin)
+	assert.Nil(t, store.UpdateBalance(context.Background(), acc.ID, 1000))
+
+	setStatusAs := func(token, action string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/account/%d/%s", acc.ID, action), nil)
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	setStatus := func(action string) *httptest.ResponseRecorder {
+		return setStatusAs(adminToken, action)
+	}
+	withdraw := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/withdraw", acc.ID), strings.NewReader(`{"amount":100}`))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+
+	// Other holders can't freeze the account, but its own holder can
+	assert.Equal(t, http.StatusForbidden, setStatusAs(recipientToken, "freeze").Code)
+	rec := setStatusAs(token, "freeze")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"frozen"`)
+
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "account_frozen")
+	rec = withdraw()
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "account_frozen")
+
+	// Only an admin can lift the freeze, which restores normal use
+	assert.Equal(t, http.StatusForbidden, setStatusAs(token, "unfreeze").Code)
+	rec = setStatus("unfreeze")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"active"`)
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 100)).Code)
+	assert.Equal(t, http.StatusOK, withdraw().Code)
+
+	// Both changes are audit-logged with who made them
+	assert.Len(t, store.audit, 2)
+	assert.Equal(t, AuditEntry{ID: 1, ActorID: acc.ID, Action: AuditAccountFreeze, AccountID: acc.ID, CreatedAt: store.audit[0].CreatedAt}, *store.audit[0])
+	assert.Equal(t, AuditEntry{ID: 2, ActorID: admin.ID, Action: AuditAccountUnfreeze, AccountID: acc.ID, CreatedAt: store.audit[1].CreatedAt}, *store.audit[1])
+
+	// Closed accounts can't be reopened this way
+	assert.Nil(t, store.UpdateAccountStatus(context.Background(), acc.ID, StatusClosed))
+	rec = setStatus("unfreeze")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "account_closed")
+}
+
+// TestClosedRecipientRejected tests that money can't be transferred into a closed account
+func TestClosedRecipientRejected(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+	assert.Nil(t, store.UpdateAccountStatus(context.Background(), recipient.ID, StatusClosed))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "recipient_closed")
+
+	acc, err := store.GetAccountByID(context.Background(), sender.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, Money(1000), acc.Balance)
+}
@@ -1,136 +1,994 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq" // Import the PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver and array support
 )
 
 // Storage defines the methods required for account storage operations
 type Storage interface {
-	CreateAccount(*Account) error
-	DeleteAccount(int) error
-	UpdateAccount(*Account) error
-	GetAccounts() ([]*Account, error)
-	GetAccountByID(int) (*Account, error)
-	GetAccountByNumber(int) (*Account, error)
+	CreateAccount(context.Context, *Account) error
+	DeleteAccount(context.Context, int) error
+	UpdateAccount(ctx context.Context, id int, firstName, lastName string) error
+	GetAccounts(context.Context) ([]*Account, error)
+	GetAccountsPaginated(ctx context.Context, limit, offset int, filter AccountFilter) ([]*Account, error)
+	GetAccountsAfter(ctx context.Context, cursorID, limit int, filter AccountFilter) ([]*Account, error)
+	CountAccounts(ctx context.Context, filter AccountFilter) (int, error)
+	SearchAccounts(ctx context.Context, query string) ([]*Account, error)
+	Truncate(context.Context) error
+	GetAccountByID(context.Context, int) (*Account, error)
+	GetAccountByNumber(context.Context, int) (*Account, error)
+	GetAccountsByIDs(context.Context, []int) ([]*Account, error)
+	AccountNumberExists(ctx context.Context, number int64) (bool, error)
+	UpdateAccountNumber(ctx context.Context, id int, number int64) error
+	CreateAccountNumberChange(context.Context, *AccountNumberChange) error
+	LockAccounts(ctx context.Context, ids []int) ([]*Account, error)
+	UpsertAccount(context.Context, *Account) error
+	WithTx(context.Context, func(tx Storage) error) error
+	UpdateBalance(ctx context.Context, id int, delta Money) error
+	SetAcceptsInbound(ctx context.Context, id int, accepts bool) error
+	SetWebhookURL(ctx context.Context, id int, url string) error
+	UpdatePin(ctx context.Context, id int, hash string) error
+	NextTransactionSeq(context.Context) (int64, error)
+	CreateTransaction(context.Context, *Transaction) error
+	SetActivated(ctx context.Context, id int) error
+	UpdateAccountStatus(ctx context.Context, id int, status string) error
+	CreateAccountToken(context.Context, *AccountToken) error
+	ConsumeAccountToken(ctx context.Context, purpose, hash string, now time.Time) (int, error)
+	GetAccountByEmail(ctx context.Context, email string) (*Account, error)
+	UpdatePassword(ctx context.Context, id int, hash string) error
+	SumTransfers(ctx context.Context, fromID, toID int, since time.Time) (int64, error)
+	SumOutgoingTransfersSince(ctx context.Context, accountID int, since time.Time) (int64, error)
+	CheckHealth(context.Context) (writable bool, err error)
+	AdminExists(context.Context) (bool, error)
+	GetTransactionsByAccount(ctx context.Context, accountID, limit, offset int) ([]*Transaction, error)
+	GetTransactionsBefore(ctx context.Context, accountID, cursorID, limit int) ([]*Transaction, error)
+	GetTransactionsSince(ctx context.Context, accountID int, since time.Time) ([]*Transaction, error)
+	GetTransactionsAfter(ctx context.Context, cursorID, limit int) ([]*Transaction, error)
+	LastTransferAt(ctx context.Context, accountID int) (time.Time, error)
+	TransferStats(ctx context.Context, fromID int) (count int, average float64, err error)
+	CreatePendingTransfer(context.Context, *PendingTransfer) error
+	GetPendingTransfer(ctx context.Context, id int) (*PendingTransfer, error)
+	UpdatePendingTransfer(ctx context.Context, id int, status string, approvedBy int) error
+	CancelExpiredTransfers(ctx context.Context, now time.Time) (int64, error)
+	LastTransactionHash(ctx context.Context, accountID int) (string, error)
+	CreateAuditEntry(context.Context, *AuditEntry) error
+	LastAuditEntryAt(ctx context.Context, action string, accountID int) (time.Time, error)
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error)
+}
+
+// accountColumns lists the 'account' columns in the order scanIntoAccount expects them
+const accountColumns = "id, first_name, last_name, number, encrypted_password, balance, created_at, role, " +
+	"type, min_balance, interest_rate, accepts_inbound, encrypted_pin, email, activated, status, deleted_at, currency, " +
+	"webhook_url"
+
+// transactionColumns lists the 'transactions' columns in the order scanTransactions expects them
+const transactionColumns = "id, reference, coalesce(from_account, 0), coalesce(to_account, 0), amount, kind, " +
+	"created_at, memo, private_note, hash, from_prev_hash, to_prev_hash, flagged, rate, period_seconds"
+
+// dbtx is the subset of *sql.DB and *sql.Tx used by the store, so the same queries can run inside a transaction
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 // PostgresStore implements the Storage interface using a PostgreSQL database
 type PostgresStore struct {
-	db *sql.DB // Database connection
+	db           dbtx          // Database connection, or the transaction the store is scoped to
+	conn         *sql.DB       // Connection pool used to begin transactions, nil when scoped to a transaction
+	queryTimeout time.Duration // Longest a single storage call may take, 0 for no limit
 }
 
-// NewPostgresStore creates and initializes a new PostgresStore instance
-func NewPostgresStore() (*PostgresStore, error) {
-	// Connection string for PostgreSQL database
-	connStr := "user=postgres dbname=postgres password=gobank sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
+// NewPostgresStore creates and initializes a new PostgresStore instance for the configured database
+func NewPostgresStore(config *Config) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", config.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
 
+	// Bound the connection pool, which is unlimited by default and can exhaust the database under load
+	db.SetMaxOpenConns(config.DBMaxOpenConns)
+	db.SetMaxIdleConns(config.DBMaxIdleConns)
+	db.SetConnMaxLifetime(config.DBConnMaxLifetime)
+
 	// Verify the database connection
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
 	return &PostgresStore{
-		db: db,
+		db:           db,
+		conn:         db,
+		queryTimeout: config.QueryTimeout,
 	}, nil
 }
 
-// Init initializes the database schema by creating necessary tables
-func (s *PostgresStore) Init() error {
-	return s.createAccountTable()
+// queryContext bounds ctx by the query timeout, so a stuck database can't hold on to requests indefinitely
+func (s *PostgresStore) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
 }
 
-// createAccountTable creates the 'account' table if it does not exist
-func (s *PostgresStore) createAccountTable() error {
-	// SQL query to create the 'account' table
-	query := `create table if not exists account (
-		id serial primary key,
-		first_name varchar(100),
-		last_name varchar(100),
-		number serial,
-		encrypted_password varchar(100),
-		balance serial,
-		created_at timestamp
-	)`
+// WithTx runs fn with a Storage scoped to a single database transaction, committing when fn
+// succeeds and rolling back every write when it returns an error
+func (s *PostgresStore) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	// Already inside a transaction, so compose with it
+	if s.conn == nil {
+		return fn(s)
+	}
 
-	_, err := s.db.Exec(query)
-	return err
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rolling back after a successful commit is a no-op
+	defer tx.Rollback()
+
+	if err := fn(&PostgresStore{db: tx, queryTimeout: s.queryTimeout}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CheckHealth checks that the database can be queried and reports whether it accepts writes,
+// which a server in recovery, such as a replica, does not
+func (s *PostgresStore) CheckHealth(ctx context.Context) (bool, error) {
+	var inRecovery bool
+	if err := s.db.QueryRowContext(ctx, "select pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return false, err
+	}
+	return !inRecovery, nil
 }
 
 // CreateAccount inserts a new account into the 'account' table
-func (s *PostgresStore) CreateAccount(acc *Account) error {
+func (s *PostgresStore) CreateAccount(ctx context.Context, acc *Account) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
 	// SQL query to insert a new account
 	query := `insert into account 
-	(first_name, last_name, number, encrypted_password, balance, created_at)
-	values ($1, $2, $3, $4, $5, $6)`
+	(first_name, last_name, number, encrypted_password, balance, created_at, role,
+	type, min_balance, interest_rate, accepts_inbound, email, activated, status, currency)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
 
-	_, err := s.db.Query(
+	_, err := s.db.ExecContext(ctx,
 		query,
 		acc.FirstName,
 		acc.LastName,
 		acc.Number,
 		acc.EncryptedPassword,
 		acc.Balance,
-		acc.CreatedAt)
+		acc.CreatedAt,
+		acc.Role,
+		acc.Type,
+		acc.MinBalance,
+		acc.InterestRate,
+		acc.AcceptsInbound,
+		acc.Email,
+		acc.Activated,
+		acc.Status,
+		acc.Currency)
 
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 
 	return nil
 }
 
-// UpdateAccount is a placeholder function for updating an account (not implemented)
-func (s *PostgresStore) UpdateAccount(*Account) error {
-	return nil
+// UpsertAccount inserts an account or, when its number already exists, overwrites the stored details
+func (s *PostgresStore) UpsertAccount(ctx context.Context, acc *Account) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into account
+	(first_name, last_name, number, encrypted_password, balance, created_at, role,
+	type, min_balance, interest_rate, accepts_inbound, email, activated, status, currency)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	on conflict (number) do update set
+		first_name = excluded.first_name,
+		last_name = excluded.last_name,
+		encrypted_password = excluded.encrypted_password,
+		balance = excluded.balance,
+		role = excluded.role,
+		type = excluded.type,
+		min_balance = excluded.min_balance,
+		interest_rate = excluded.interest_rate,
+		accepts_inbound = excluded.accepts_inbound,
+		email = excluded.email,
+		activated = excluded.activated,
+		status = excluded.status,
+		currency = excluded.currency,
+		deleted_at = null
+	returning id`
+
+	err := s.db.QueryRowContext(ctx,
+		query,
+		acc.FirstName,
+		acc.LastName,
+		acc.Number,
+		acc.EncryptedPassword,
+		acc.Balance,
+		acc.CreatedAt,
+		acc.Role,
+		acc.Type,
+		acc.MinBalance,
+		acc.InterestRate,
+		acc.AcceptsInbound,
+		acc.Email,
+		acc.Activated,
+		acc.Status,
+		acc.Currency).Scan(&acc.ID)
+
+	return classifyError(err)
+}
+
+// UpdateAccount sets the name of the account holder of the account with the given ID
+func (s *PostgresStore) UpdateAccount(ctx context.Context, id int, firstName, lastName string) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set first_name = $2, last_name = $3 where id = $1", id, firstName, lastName)
+	return err
+}
+
+// UpdateBalance adds delta, which may be negative, to the balance of the account with the given ID
+func (s *PostgresStore) UpdateBalance(ctx context.Context, id int, delta Money) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set balance = balance + $2 where id = $1", id, delta)
+	return err
+}
+
+// SetAcceptsInbound sets whether the account with the given ID accepts incoming transfers
+func (s *PostgresStore) SetAcceptsInbound(ctx context.Context, id int, accepts bool) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set accepts_inbound = $2 where id = $1", id, accepts)
+	return err
+}
+
+// SetWebhookURL sets the URL notified of balance changes of the account with the given ID, empty for none
+func (s *PostgresStore) SetWebhookURL(ctx context.Context, id int, url string) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set webhook_url = $2 where id = $1", id, url)
+	return err
+}
+
+// UpdatePin stores the hashed transaction PIN of the account with the given ID
+func (s *PostgresStore) UpdatePin(ctx context.Context, id int, hash string) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set encrypted_pin = $2 where id = $1", id, hash)
+	return err
+}
+
+// NextTransactionSeq returns the next number of the sequence transaction references are built from
+func (s *PostgresStore) NextTransactionSeq(ctx context.Context) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var seq int64
+	err := s.db.QueryRowContext(ctx, "select nextval('transaction_reference_seq')").Scan(&seq)
+	return seq, err
+}
+
+// CreateTransaction inserts a transaction into the 'transactions' table and sets its ID
+func (s *PostgresStore) CreateTransaction(ctx context.Context, t *Transaction) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into transactions
+	(reference, from_account, to_account, amount, kind, created_at, memo, private_note,
+	hash, from_prev_hash, to_prev_hash, flagged, rate, period_seconds)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	returning id`
+
+	err := s.db.QueryRowContext(ctx,
+		query,
+		t.Reference,
+		nullableID(t.FromAccount),
+		nullableID(t.ToAccount),
+		t.Amount,
+		t.Kind,
+		t.CreatedAt,
+		t.Memo,
+		t.PrivateNote,
+		t.Hash,
+		t.FromPrevHash,
+		t.ToPrevHash,
+		t.Flagged,
+		t.Rate,
+		t.PeriodSeconds).Scan(&t.ID)
+
+	return classifyError(err)
+}
+
+// UpdatePassword stores the hashed password of the account with the given ID
+func (s *PostgresStore) UpdatePassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set encrypted_password = $2 where id = $1", id, hash)
+	return err
+}
+
+// UpdateAccountStatus sets the lifecycle state of the account with the given ID
+func (s *PostgresStore) UpdateAccountStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set status = $2 where id = $1", id, status)
+	return err
+}
+
+// SetActivated marks the account with the given ID as activated
+func (s *PostgresStore) SetActivated(ctx context.Context, id int) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set activated = true where id = $1", id)
+	return err
+}
+
+// CreateAccountToken inserts a hashed account token into the 'account_tokens' table and sets its ID
+func (s *PostgresStore) CreateAccountToken(ctx context.Context, t *AccountToken) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into account_tokens
+	(account_id, purpose, token_hash, expires_at)
+	values ($1, $2, $3, $4)
+	returning id`
+
+	err := s.db.QueryRowContext(ctx, query, t.AccountID, t.Purpose, t.TokenHash, t.ExpiresAt).Scan(&t.ID)
+	return classifyError(err)
+}
+
+// ConsumeAccountToken marks the unused, unexpired token with the given purpose and hash as used and
+// returns the ID of its account, so every token can be used only once
+func (s *PostgresStore) ConsumeAccountToken(ctx context.Context, purpose, hash string, now time.Time) (int, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `update account_tokens set used_at = $3
+	where purpose = $1 and token_hash = $2 and used_at is null and expires_at > $3
+	returning account_id`
+
+	var accountID int
+	err := s.db.QueryRowContext(ctx, query, purpose, hash, now).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidToken
+	}
+	return accountID, err
+}
+
+// GetTransactionsByAccount retrieves the transactions debiting or crediting the account, newest first,
+// skipping the first offset ones and returning at most limit of them; a limit of 0 returns all of them
+func (s *PostgresStore) GetTransactionsByAccount(ctx context.Context, accountID, limit, offset int) ([]*Transaction, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select ` + transactionColumns + ` from transactions
+	where from_account = $1 or to_account = $1
+	order by created_at desc, id desc
+	limit $2 offset $3`
+
+	// A null limit is the same as no limit at all
+	var pageLimit sql.NullInt64
+	if limit > 0 {
+		pageLimit = sql.NullInt64{Int64: int64(limit), Valid: true}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, accountID, pageLimit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTransactions(rows)
+}
+
+// GetTransactionsBefore retrieves at most limit transactions debiting or crediting the account, newest first by ID,
+// with an ID below cursorID; a cursorID of 0 starts from the newest transaction
+func (s *PostgresStore) GetTransactionsBefore(ctx context.Context, accountID, cursorID, limit int) ([]*Transaction, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select ` + transactionColumns + ` from transactions
+	where (from_account = $1 or to_account = $1) and ($2 = 0 or id < $2)
+	order by id desc
+	limit $3`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID, cursorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTransactions(rows)
+}
+
+// GetTransactionsAfter retrieves at most limit transactions of all accounts with an ID above cursorID, oldest first
+func (s *PostgresStore) GetTransactionsAfter(ctx context.Context, cursorID, limit int) ([]*Transaction, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+transactionColumns+" from transactions where id > $1 order by id limit $2", cursorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTransactions(rows)
+}
+
+// GetTransactionsSince retrieves the transactions debiting or crediting the account at or after since, oldest first
+func (s *PostgresStore) GetTransactionsSince(ctx context.Context, accountID int, since time.Time) ([]*Transaction, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select ` + transactionColumns + ` from transactions
+	where (from_account = $1 or to_account = $1) and created_at >= $2
+	order by created_at, id`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTransactions(rows)
+}
+
+// scanTransactions scans the rows of the 'transactions' table, selected as transactionColumns
+func scanTransactions(rows *sql.Rows) ([]*Transaction, error) {
+	transactions := []*Transaction{}
+	for rows.Next() {
+		t := new(Transaction)
+		err := rows.Scan(
+			&t.ID,
+			&t.Reference,
+			&t.FromAccount,
+			&t.ToAccount,
+			&t.Amount,
+			&t.Kind,
+			&t.CreatedAt,
+			&t.Memo,
+			&t.PrivateNote,
+			&t.Hash,
+			&t.FromPrevHash,
+			&t.ToPrevHash,
+			&t.Flagged,
+			&t.Rate,
+			&t.PeriodSeconds)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// LastTransferAt returns the time of the latest transfer sent by the account, zero when it never sent one
+func (s *PostgresStore) LastTransferAt(ctx context.Context, accountID int) (time.Time, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var last sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"select max(created_at) from transactions where kind = $1 and from_account = $2",
+		TransactionTransfer,
+		accountID).Scan(&last)
+	return last.Time, err
+}
+
+// CreatePendingTransfer inserts a transfer awaiting approval and sets its ID
+func (s *PostgresStore) CreatePendingTransfer(ctx context.Context, p *PendingTransfer) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into pending_transfers
+	(from_account, to_account, amount, memo, private_note, status, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)
+	returning id`
+
+	return s.db.QueryRowContext(ctx,
+		query,
+		p.FromAccount,
+		p.ToAccount,
+		p.Amount,
+		p.Memo,
+		p.PrivateNote,
+		p.Status,
+		p.CreatedAt,
+		p.ExpiresAt).Scan(&p.ID)
+}
+
+// GetPendingTransfer retrieves a transfer awaiting approval by ID, locking it for the current transaction
+func (s *PostgresStore) GetPendingTransfer(ctx context.Context, id int) (*PendingTransfer, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select id, from_account, to_account, amount, memo, private_note, status, created_at, expires_at,
+	coalesce(approved_by, 0)
+	from pending_transfers where id = $1 for update`
+
+	p := new(PendingTransfer)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&p.ID,
+		&p.FromAccount,
+		&p.ToAccount,
+		&p.Amount,
+		&p.Memo,
+		&p.PrivateNote,
+		&p.Status,
+		&p.CreatedAt,
+		&p.ExpiresAt,
+		&p.ApprovedBy)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("pending transfer %d %w", id, errTransferNotFound)
+	}
+	return p, err
 }
 
-// DeleteAccount deletes an account from the 'account' table by ID
-func (s *PostgresStore) DeleteAccount(id int) error {
-	_, err := s.db.Query("delete from account where id = $1", id)
+// UpdatePendingTransfer sets the state of a pending transfer and the account that approved it
+func (s *PostgresStore) UpdatePendingTransfer(ctx context.Context, id int, status string, approvedBy int) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"update pending_transfers set status = $2, approved_by = $3 where id = $1",
+		id,
+		status,
+		nullableID(approvedBy))
+	return err
+}
+
+// CancelExpiredTransfers cancels the pending transfers that expired unapproved and returns how many
+func (s *PostgresStore) CancelExpiredTransfers(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		"update pending_transfers set status = $1 where status = $2 and expires_at <= $3",
+		TransferCancelled,
+		TransferPendingApproval,
+		now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// LastTransactionHash returns the hash of the latest hashed transaction of the account, empty when there is none
+func (s *PostgresStore) LastTransactionHash(ctx context.Context, accountID int) (string, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select hash from transactions
+	where (from_account = $1 or to_account = $1) and hash <> ''
+	order by id desc limit 1`
+
+	var hash string
+	err := s.db.QueryRowContext(ctx, query, accountID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// CreateAuditEntry inserts an entry into the 'audit_log' table and sets its ID
+func (s *PostgresStore) CreateAuditEntry(ctx context.Context, e *AuditEntry) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into audit_log
+	(actor_id, action, account_id, created_at)
+	values ($1, $2, $3, $4)
+	returning id`
+
+	return s.db.QueryRowContext(ctx, query, e.ActorID, e.Action, e.AccountID, e.CreatedAt).Scan(&e.ID)
+}
+
+// LastAuditEntryAt returns the time the action was last performed on the account, zero when it never was
+func (s *PostgresStore) LastAuditEntryAt(ctx context.Context, action string, accountID int) (time.Time, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var last sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"select max(created_at) from audit_log where action = $1 and account_id = $2",
+		action,
+		accountID).Scan(&last)
+	return last.Time, err
+}
+
+// RevokeToken revokes the JWT token with the given ID until it expires
+func (s *PostgresStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"insert into revoked_tokens (jti, expires_at) values ($1, $2) on conflict (jti) do nothing",
+		jti,
+		expiresAt)
+	return err
+}
+
+// IsTokenRevoked reports whether the JWT token with the given ID was revoked
+func (s *PostgresStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, "select exists (select 1 from revoked_tokens where jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// PurgeExpiredRevocations deletes the revocations of the tokens expired by now, which are rejected
+// on their expiry anyway, and returns how many were deleted
+func (s *PostgresStore) PurgeExpiredRevocations(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "delete from revoked_tokens where expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SumTransfers returns the total amount transferred from one account to another since the given time
+func (s *PostgresStore) SumTransfers(ctx context.Context, fromID, toID int, since time.Time) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select coalesce(sum(amount), 0) from transactions
+	where kind = $1 and from_account = $2 and to_account = $3 and created_at >= $4`
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, query, TransactionTransfer, fromID, toID, since).Scan(&total)
+	return total, err
+}
+
+// SumOutgoingTransfersSince returns the total amount the account transferred to any other account since the given time
+func (s *PostgresStore) SumOutgoingTransfersSince(ctx context.Context, accountID int, since time.Time) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select coalesce(sum(amount), 0) from transactions
+	where kind = $1 and from_account = $2 and created_at >= $3`
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, query, TransactionTransfer, accountID, since).Scan(&total)
+	return total, err
+}
+
+// TransferStats returns the number and average amount of the transfers sent by the account
+func (s *PostgresStore) TransferStats(ctx context.Context, fromID int) (int, float64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select count(*), coalesce(avg(amount), 0) from transactions
+	where kind = $1 and from_account = $2`
+
+	var count int
+	var average float64
+	err := s.db.QueryRowContext(ctx, query, TransactionTransfer, fromID).Scan(&count, &average)
+	return count, average, err
+}
+
+// DeleteAccount soft deletes the account with the given ID, keeping its row and history
+// Deleted accounts are left out of the account lookups
+func (s *PostgresStore) DeleteAccount(ctx context.Context, id int) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set deleted_at = $2 where id = $1 and deleted_at is null", id, time.Now().UTC())
 	return err
 }
 
 // GetAccountByNumber retrieves an account from the 'account' table by account number
-func (s *PostgresStore) GetAccountByNumber(number int) (*Account, error) {
-	rows, err := s.db.Query("select * from account where number = $1", number)
+func (s *PostgresStore) GetAccountByNumber(ctx context.Context, number int) (*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where number = $1 and deleted_at is null", number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAccount(rows)
+	}
+
+	return nil, fmt.Errorf("account with number [%d] %w", number, errAccountNotFound)
+}
+
+// AccountNumberExists reports whether an account with the given number is stored, or the number was retired
+// by a rotation and must not be reused
+func (s *PostgresStore) AccountNumberExists(ctx context.Context, number int64) (bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `select exists (select 1 from account where number = $1)
+	or exists (select 1 from account_number_history where old_number = $1)`
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, number).Scan(&exists)
+	return exists, err
+}
+
+// UpdateAccountNumber gives the account with the given ID a new account number
+func (s *PostgresStore) UpdateAccountNumber(ctx context.Context, id int, number int64) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "update account set number = $2 where id = $1", id, number)
+	return classifyError(err)
+}
+
+// CreateAccountNumberChange records an account number retired by a rotation
+func (s *PostgresStore) CreateAccountNumberChange(ctx context.Context, c *AccountNumberChange) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := `insert into account_number_history
+	(account_id, old_number, new_number, actor_id, rotated_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`
+
+	err := s.db.QueryRowContext(ctx, query, c.AccountID, c.OldNumber, c.NewNumber, c.ActorID, c.RotatedAt).Scan(&c.ID)
+	return classifyError(err)
+}
+
+// AdminExists reports whether any account has the admin role
+func (s *PostgresStore) AdminExists(ctx context.Context) (bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "select exists (select 1 from account where role = $1)", RoleAdmin).Scan(&exists)
+	return exists, err
+}
+
+// GetAccountByEmail retrieves an account from the 'account' table by email address
+func (s *PostgresStore) GetAccountByEmail(ctx context.Context, email string) (*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	if email == "" {
+		return nil, fmt.Errorf("account with email [%s] %w", email, errAccountNotFound)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where email = $1 and deleted_at is null", email)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("account with number [%d] not found", number)
+	return nil, fmt.Errorf("account with email [%s] %w", email, errAccountNotFound)
 }
 
 // GetAccountByID retrieves an account from the 'account' table by account ID
-func (s *PostgresStore) GetAccountByID(id int) (*Account, error) {
-	rows, err := s.db.Query("select * from account where id = $1", id)
+func (s *PostgresStore) GetAccountByID(ctx context.Context, id int) (*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where id = $1 and deleted_at is null", id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("account %d not found", id)
+	return nil, fmt.Errorf("account %d %w", id, errAccountNotFound)
 }
 
-// GetAccounts retrieves all accounts from the 'account' table
-func (s *PostgresStore) GetAccounts() ([]*Account, error) {
-	rows, err := s.db.Query("select * from account")
+// GetAccounts retrieves all accounts that are not deleted from the 'account' table
+func (s *PostgresStore) GetAccounts(ctx context.Context) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where deleted_at is null")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// accountFilterCondition is the where condition of an AccountFilter, taking its fields as the parameters $1 to $3
+const accountFilterCondition = "($1 or deleted_at is null) and " +
+	"($2::timestamp is null or created_at >= $2) and ($3::timestamp is null or created_at <= $3)"
+
+// GetAccountsPaginated retrieves at most limit accounts ordered by ID, skipping the first offset ones
+// Only the accounts matching the filter are listed
+func (s *PostgresStore) GetAccountsPaginated(ctx context.Context, limit, offset int, filter AccountFilter) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := "select " + accountColumns + " from account where " + accountFilterCondition + " order by id limit $4 offset $5"
+	rows, err := s.db.QueryContext(ctx,
+		query,
+		filter.IncludeDeleted,
+		nullableTime(filter.CreatedAfter),
+		nullableTime(filter.CreatedBefore),
+		limit,
+		offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// GetAccountsAfter retrieves at most limit accounts matching the filter with an ID above cursorID, ordered by ID
+// Unlike an offset, the cursor stays put when accounts are created or deleted between two pages
+func (s *PostgresStore) GetAccountsAfter(ctx context.Context, cursorID, limit int, filter AccountFilter) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	query := "select " + accountColumns + " from account where " + accountFilterCondition + " and id > $4 order by id limit $5"
+	rows, err := s.db.QueryContext(ctx,
+		query,
+		filter.IncludeDeleted,
+		nullableTime(filter.CreatedAfter),
+		nullableTime(filter.CreatedBefore),
+		cursorID,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// SearchAccounts returns the accounts whose holder name contains the query, ignoring case
+func (s *PostgresStore) SearchAccounts(ctx context.Context, query string) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	// Match the query literally, not as a pattern
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := s.db.QueryContext(ctx,
+		"select "+accountColumns+" from account where deleted_at is null and first_name || ' ' || last_name ilike $1 order by id",
+		pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// CountAccounts returns the number of stored accounts matching the filter
+func (s *PostgresStore) CountAccounts(ctx context.Context, filter AccountFilter) (int, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"select count(*) from account where "+accountFilterCondition,
+		filter.IncludeDeleted,
+		nullableTime(filter.CreatedAfter),
+		nullableTime(filter.CreatedBefore)).Scan(&count)
+	return count, err
+}
+
+// Truncate deletes every account and all the data attached to them, restarting the ids
+func (s *PostgresStore) Truncate(ctx context.Context) error {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `truncate table account, transactions, account_tokens, pending_transfers, audit_log,
+	account_number_history restart identity`)
+	return err
+}
+
+// GetAccountsByIDs retrieves the accounts with the given IDs in a single query, omitting missing ones
+func (s *PostgresStore) GetAccountsByIDs(ctx context.Context, ids []int) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where id = any($1) and deleted_at is null order by id", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// LockAccounts retrieves the accounts with the given IDs and locks them for update until the end of the
+// transaction. Rows are locked in id order so that concurrent transactions over the same accounts can't deadlock
+func (s *PostgresStore) LockAccounts(ctx context.Context, ids []int) ([]*Account, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "select "+accountColumns+" from account where id = any($1) and deleted_at is null order by id for update", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
 	accounts := []*Account{}
 	for rows.Next() {
@@ -154,7 +1012,35 @@ func scanIntoAccount(rows *sql.Rows) (*Account, error) {
 		&account.Number,
 		&account.EncryptedPassword,
 		&account.Balance,
-		&account.CreatedAt)
+		&account.CreatedAt,
+		&account.Role,
+		&account.Type,
+		&account.MinBalance,
+		&account.InterestRate,
+		&account.AcceptsInbound,
+		&account.EncryptedPin,
+		&account.Email,
+		&account.Activated,
+		&account.Status,
+		&account.DeletedAt,
+		&account.Currency,
+		&account.WebhookURL)
 
 	return account, err
 }
+
+// nullableID stores an unset (zero) account ID as NULL
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullableTime stores an unset (zero) time as NULL
+func nullableTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
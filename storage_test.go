@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPostgresStore connects to the development database, skipping the test when it is unavailable
+func newTestPostgresStore(t *testing.T) *PostgresStore {
+	store, err := NewPostgresStore(newTestConfig(t))
+	if err != nil {
+		t.Skipf("postgres not available: %v", err)
+	}
+	assert.Nil(t, store.Init())
+	return store
+}
+
+// newStoredTestAccount creates an account with a random number that is not stored yet
+func newStoredTestAccount(t *testing.T) *Account {
+	acc, err := NewAccount("tx", "test", "hunter88888", AccountType{Name: "checking"})
+	assert.Nil(t, err)
+	acc.Number = 900000000 + int64(rand.Intn(99999999))
+	return acc
+}
+
+// TestWithTxRollsBackCompositeWrites tests that a failure mid-composite rolls back all writes
+func TestWithTxRollsBackCompositeWrites(t *testing.T) {
+	store := newTestPostgresStore(t)
+	first := newStoredTestAccount(t)
+	second := newStoredTestAccount(t)
+	failure := errors.New("initial deposit failed")
+
+	err := store.WithTx(context.Background(), func(tx Storage) error {
+		if err := tx.CreateAccount(context.Background(), first); err != nil {
+			return err
+		}
+		if err := tx.CreateAccount(context.Background(), second); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	// Neither write survived the rollback
+	_, err = store.GetAccountByNumber(context.Background(), int(first.Number))
+	assert.NotNil(t, err)
+	_, err = store.GetAccountByNumber(context.Background(), int(second.Number))
+	assert.NotNil(t, err)
+
+	// A successful composite is committed
+	err = store.WithTx(context.Background(), func(tx Storage) error {
+		return tx.CreateAccount(context.Background(), first)
+	})
+	assert.Nil(t, err)
+	_, err = store.GetAccountByNumber(context.Background(), int(first.Number))
+	assert.Nil(t, err)
+}
+
+// TestQueryTimeout tests that storage calls get a deadline from the configured query timeout
+func TestQueryTimeout(t *testing.T) {
+	store := &PostgresStore{queryTimeout: time.Second}
+	ctx, cancel := store.queryContext(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	// Without a timeout the caller's context is used as is
+	store.queryTimeout = 0
+	ctx, cancel = store.queryContext(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
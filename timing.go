@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timingKey is the context key of the request timings
+type timingKey struct{}
+
+// requestTimings collects the time spent in the phases of handling a request, such as auth
+type requestTimings struct {
+	mu     sync.Mutex
+	names  []string // Phase names in the order they were first recorded
+	totals map[string]time.Duration
+}
+
+// Add records d as spent in the named phase
+func (t *requestTimings) Add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.totals[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.totals[name] += d
+}
+
+// recordTiming records the time since start as spent in the named phase of the request
+// It does nothing when response timing is disabled
+func recordTiming(ctx context.Context, name string, start time.Time) {
+	if t, ok := ctx.Value(timingKey{}).(*requestTimings); ok {
+		t.Add(name, time.Since(start))
+	}
+}
+
+// timingResponseWriter adds the timing headers right before the response status is written
+type timingResponseWriter struct {
+	http.ResponseWriter
+	start   time.Time
+	timings *requestTimings
+	written bool
+}
+
+func (w *timingResponseWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.setHeaders()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingResponseWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// setHeaders reports the total duration in X-Response-Time-Ms and the phases in Server-Timing
+func (w *timingResponseWriter) setHeaders() {
+	total := msec(time.Since(w.start))
+
+	w.timings.mu.Lock()
+	metrics := make([]string, 0, len(w.timings.names)+1)
+	for _, name := range w.timings.names {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%s", name, msec(w.timings.totals[name])))
+	}
+	w.timings.mu.Unlock()
+	metrics = append(metrics, "total;dur="+total)
+
+	w.Header().Set("X-Response-Time-Ms", total)
+	w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+}
+
+// msec formats a duration in milliseconds
+func msec(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
+
+// timingMiddleware reports how long the server took to handle each request in the response headers
+func timingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timings := &requestTimings{totals: map[string]time.Duration{}}
+		tw := &timingResponseWriter{ResponseWriter: w, start: time.Now(), timings: timings}
+
+		ctx := context.WithValue(r.Context(), timingKey{}, timings)
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResponseTimingHeaders tests that responses report their handling time
+func TestResponseTimingHeaders(t *testing.T) {
+	config := newTestConfig(t)
+	config.ResponseTiming = true
+	server, store := newTestServer(config)
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d", acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	total, err := strconv.ParseFloat(rec.Header().Get("X-Response-Time-Ms"), 64)
+	assert.Nil(t, err)
+	assert.True(t, total >= 0)
+
+	// The authentication is broken out of the total
+	serverTiming := rec.Header().Get("Server-Timing")
+	assert.True(t, strings.HasPrefix(serverTiming, "auth;dur="), serverTiming)
+	assert.Contains(t, serverTiming, "total;dur=")
+
+	// Disabled by default
+	server, _ = newTestServer(newTestConfig(t))
+	rec = serve(server, httptest.NewRequest("GET", "/account", nil))
+	assert.Empty(t, rec.Header().Get("X-Response-Time-Ms"))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+// errInvalidToken is returned for unknown, used and expired account tokens alike
+var errInvalidToken = errors.New("invalid or expired token")
+
+// newAccountToken generates a random token for the account and returns it with the record to
+// store, which only holds the token hash
+func newAccountToken(accountID int, purpose string, ttl time.Duration, now time.Time) (string, *AccountToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(b)
+
+	return token, &AccountToken{
+		AccountID: accountID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// newTokenID returns a random ID for a JWT token, under which the token can be revoked
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash an account token is stored under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RunRevocationPurge deletes the revocations of expired tokens every interval until ctx is done,
+// so the revocation table only holds the tokens that would still be accepted otherwise
+func (s *APIServer) RunRevocationPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.store.PurgeExpiredRevocations(ctx, s.now().UTC())
+			if err != nil {
+				log.Printf("purging expired token revocations failed: %v\n", err)
+				continue
+			}
+			log.Printf("purged %d expired token revocations\n", purged)
+		}
+	}
+}
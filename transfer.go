@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// validateTransferRequest checks the parts of a transfer request that don't depend on the accounts
+func (s *APIServer) validateTransferRequest(req *TransferRequest) error {
+	if req.Amount <= 0 {
+		return &TransferError{Code: "invalid_amount", Message: "transfer amount must be positive"}
+	}
+
+	// Per-transfer limits catch fat-fingered amounts before they reach the accounts
+	if floor := Money(s.config.TransferMinAmount); req.Amount < floor {
+		return &TransferError{Code: "amount_below_minimum", Message: fmt.Sprintf("transfer amount must be at least %s", floor)}
+	}
+	if ceiling := Money(s.config.TransferMaxAmount); ceiling > 0 && req.Amount > ceiling {
+		return &TransferError{Code: "amount_above_maximum", Message: fmt.Sprintf("transfer amount must be at most %s", ceiling)}
+	}
+	if utf8.RuneCountInString(req.Memo) > s.config.MemoMaxLength || utf8.RuneCountInString(req.Note) > s.config.MemoMaxLength {
+		return &TransferError{Code: "memo_too_long", Message: fmt.Sprintf("memos and notes are limited to %d characters", s.config.MemoMaxLength)}
+	}
+	return nil
+}
+
+// executeTransfer moves the requested amount from the sender to the recipient account in a single
+// transaction, records it and returns the receipt
+func (s *APIServer) executeTransfer(ctx context.Context, senderID int, req *TransferRequest) (*TransferReceipt, error) {
+	if err := s.validateTransferRequest(req); err != nil {
+		return nil, err
+	}
+
+	var receipt *TransferReceipt
+	err := s.store.WithTx(ctx, func(tx Storage) error {
+		var err error
+		receipt, err = s.transfer(ctx, tx, senderID, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.webhooks.Notify(receipt.changes...)
+
+	return receipt, nil
+}
+
+// transfer moves the requested amount from the sender to the recipient account within tx,
+// records it and returns the receipt
+func (s *APIServer) transfer(ctx context.Context, tx Storage, senderID int, req *TransferRequest) (*TransferReceipt, error) {
+	// Resolve the recipient before taking any locks, so unknown destinations fail fast
+	recipient, err := tx.GetAccountByNumber(ctx, req.ToAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock both parties in id order; the balances are read under the lock
+	sender, recipient, err := lockTransferAccounts(ctx, tx, senderID, recipient.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Throttle rapid-fire transfers from the same account
+	now := s.now().UTC()
+	if s.config.TransferCooldown > 0 {
+		last, err := tx.LastTransferAt(ctx, sender.ID)
+		if err != nil {
+			return nil, err
+		}
+		if wait := last.Add(s.config.TransferCooldown).Sub(now); !last.IsZero() && wait > 0 {
+			return nil, &ErrTooManyRequests{Code: "transfer_cooldown", Message: "too many transfers, please wait before retrying", RetryAfter: wait}
+		}
+	}
+
+	if err := checkAccountStatus(sender); err != nil {
+		return nil, err
+	}
+	if s.config.RequireActivation && !sender.Activated {
+		return nil, &TransferError{Code: "account_not_activated", Message: "activate your account before transferring"}
+	}
+	if sender.ID == recipient.ID {
+		return nil, &TransferError{Code: "same_account", Message: "cannot transfer to the same account"}
+	}
+
+	// Closed accounts, including deleted ones, don't receive money anymore
+	if recipient.Status == StatusClosed || recipient.DeletedAt != nil {
+		return nil, &TransferError{Code: "recipient_closed", Message: "recipient account is closed"}
+	}
+
+	// Money is never converted implicitly between currencies
+	if s.currencyOf(sender) != s.currencyOf(recipient) {
+		return nil, &TransferError{
+			Code:    "currency_mismatch",
+			Message: fmt.Sprintf("cannot transfer from a %s account to a %s account", s.currencyOf(sender), s.currencyOf(recipient)),
+		}
+	}
+
+	// The recipient may have opted out of incoming transfers
+	if !recipient.AcceptsInbound {
+		return nil, &TransferError{Code: "inbound_disabled", Message: "recipient account does not accept incoming transfers"}
+	}
+
+	// The sender may not drop below the minimum balance of its account type
+	amount := req.Amount
+	if sender.Balance-amount < sender.MinBalance {
+		return nil, &TransferError{Code: "insufficient_funds", Message: "insufficient funds"}
+	}
+
+	// Cap the total sent to this recipient over the UTC day
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if s.config.DestinationDailyCap > 0 {
+		sent, err := tx.SumTransfers(ctx, sender.ID, recipient.ID, day)
+		if err != nil {
+			return nil, err
+		}
+		if sent+int64(amount) > s.config.DestinationDailyCap {
+			return nil, &TransferError{
+				Code:    "destination_limit_exceeded",
+				Message: fmt.Sprintf("daily limit of %d to this recipient exceeded, %d left today", s.config.DestinationDailyCap, max64(s.config.DestinationDailyCap-sent, 0)),
+			}
+		}
+	}
+
+	// Cap the total the sender sends to anyone over the UTC day
+	if s.config.DailyTransferLimit > 0 {
+		sent, err := tx.SumOutgoingTransfersSince(ctx, sender.ID, day)
+		if err != nil {
+			return nil, err
+		}
+		if sent+int64(amount) > s.config.DailyTransferLimit {
+			return nil, &TransferError{
+				Code:    "daily_limit_exceeded",
+				Message: fmt.Sprintf("daily transfer limit of %d exceeded, %d left today", s.config.DailyTransferLimit, max64(s.config.DailyTransferLimit-sent, 0)),
+			}
+		}
+	}
+
+	// Flag amounts far above what the sender usually transfers
+	flagged, err := s.isAnomalous(ctx, tx, sender.ID, int64(amount))
+	if err != nil {
+		return nil, err
+	}
+
+	// Debit the sender and credit the recipient
+	if err := tx.UpdateBalance(ctx, sender.ID, -amount); err != nil {
+		return nil, err
+	}
+	if err := tx.UpdateBalance(ctx, recipient.ID, amount); err != nil {
+		return nil, err
+	}
+
+	// Record the transfer under a new reference
+	transaction := &Transaction{
+		FromAccount: sender.ID,
+		ToAccount:   recipient.ID,
+		Amount:      amount,
+		Kind:        TransactionTransfer,
+		CreatedAt:   now,
+		Memo:        req.Memo,
+		PrivateNote: req.Note,
+		Flagged:     flagged,
+	}
+	if err := s.recordTransaction(ctx, tx, transaction); err != nil {
+		return nil, err
+	}
+
+	// The locked accounts still hold the balances from before the transfer
+	sender.Balance -= amount
+	recipient.Balance += amount
+
+	return &TransferReceipt{
+		Reference:     transaction.Reference,
+		CreatedAt:     transaction.CreatedAt,
+		Amount:        amount,
+		AmountDisplay: formatMoney(amount, s.currencyOf(sender), s.config.DisplayLocale),
+		Currency:      s.currencyOf(sender),
+		Fee:           0,
+		FromAccount:   maskNumber(sender.Number),
+		ToAccount:     maskNumber(recipient.Number),
+		SenderBalance: sender.Balance,
+		changes: []balanceChange{
+			{Account: sender, Kind: TransactionTransfer, Amount: -amount},
+			{Account: recipient, Kind: TransactionTransfer, Amount: amount},
+		},
+	}, nil
+}
+
+// lockTransferAccounts locks the sender and recipient accounts for the rest of tx and returns their
+// current state
+func lockTransferAccounts(ctx context.Context, tx Storage, senderID, recipientID int) (sender *Account, recipient *Account, err error) {
+	accounts, err := tx.LockAccounts(ctx, []int{senderID, recipientID})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, acc := range accounts {
+		if acc.ID == senderID {
+			sender = acc
+		}
+		if acc.ID == recipientID {
+			recipient = acc
+		}
+	}
+	if sender == nil {
+		return nil, nil, fmt.Errorf("account %d %w", senderID, errAccountNotFound)
+	}
+	if recipient == nil {
+		return nil, nil, fmt.Errorf("account %d %w", recipientID, errAccountNotFound)
+	}
+	return sender, recipient, nil
+}
+
+// recordTransaction stores the transaction under a new reference, chained to the previous transactions of
+// its accounts when enabled; the balances must already be updated within tx
+func (s *APIServer) recordTransaction(ctx context.Context, tx Storage, t *Transaction) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = s.now().UTC()
+	}
+
+	seq, err := tx.NextTransactionSeq(ctx)
+	if err != nil {
+		return err
+	}
+	t.Reference = newTransactionReference(s.config.TransactionRefPrefix, t.CreatedAt, seq)
+
+	if s.config.LedgerHashChain {
+		if err := chainTransaction(ctx, tx, t); err != nil {
+			return err
+		}
+	}
+	return tx.CreateTransaction(ctx, t)
+}
+
+// isAnomalous reports whether the amount is far above the average of the transfers the sender made so far
+func (s *APIServer) isAnomalous(ctx context.Context, store Storage, senderID int, amount int64) (bool, error) {
+	if s.config.AnomalyFactor <= 0 {
+		return false, nil
+	}
+	count, average, err := store.TransferStats(ctx, senderID)
+	if err != nil {
+		return false, err
+	}
+	// Too short a history says nothing about what is usual for the account
+	if count == 0 || count < s.config.AnomalyMinHistory {
+		return false, nil
+	}
+	return float64(amount) > average*float64(s.config.AnomalyFactor), nil
+}
+
+// max64 returns the larger of a and b
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// transferRequest builds an authenticated transfer request
+func transferRequest(token string, to int64, amount int) *http.Request {
+	body := fmt.Sprintf(`{"toAccount":%d,"amount":%d}`, to, amount)
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+	req.Header.Set("x-jwt-token", token)
+	return req
+}
+
+// TestTransferMovesBalance tests that a transfer debits the sender and credits the recipient
+func TestTransferMovesBalance(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 300))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, store.transactions, 1)
+
+	sender, _ = store.GetAccountByID(context.Background(), sender.ID)
+	recipient, _ = store.GetAccountByID(context.Background(), recipient.ID)
+	assert.Equal(t, Money(700), sender.Balance)
+	assert.Equal(t, Money(300), recipient.Balance)
+
+	// Overdrawing is refused
+	rec = serve(server, transferRequest(token, recipient.Number, 701))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "insufficient_funds")
+}
+
+// TestTransferToOptOutAccountRejected tests that accounts opted out of inbound transfers reject them
+func TestTransferToOptOutAccountRejected(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, recipientToken := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	// The recipient opts out of incoming transfers
+	req := httptest.NewRequest("PUT", fmt.Sprintf("/account/%d/inbound", recipient.ID), strings.NewReader(`{"acceptsInbound":false}`))
+	req.Header.Set("x-jwt-token", recipientToken)
+	rec := serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(server, transferRequest(token, recipient.Number, 300))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"inbound_disabled"`)
+
+	// Neither balance moved
+	sender, _ = store.GetAccountByID(context.Background(), sender.ID)
+	recipient, _ = store.GetAccountByID(context.Background(), recipient.ID)
+	assert.Equal(t, Money(1000), sender.Balance)
+	assert.Equal(t, Money(0), recipient.Balance)
+}
+
+// TestHighValueTransferRequiresPin tests that transfers above the threshold need the correct PIN
+func TestHighValueTransferRequiresPin(t *testing.T) {
+	config := newTestConfig(t)
+	config.PinThreshold = 500
+	config.PinMaxAttempts = 2
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 10000))
+
+	// Without an enrolled PIN, high-value transfers are refused while small ones go through
+	rec := serve(server, transferRequest(token, recipient.Number, 1000))
+	assert.Contains(t, rec.Body.String(), "pin_required")
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Enroll a PIN
+	req := httptest.NewRequest("POST", fmt.Sprintf("/account/%d/pin", sender.ID), strings.NewReader(`{"password":"hunter88888","pin":"4321"}`))
+	req.Header.Set("x-jwt-token", token)
+	rec = serve(server, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	withPin := func(pin string) *http.Request {
+		body := fmt.Sprintf(`{"toAccount":%d,"amount":1000,"pin":"%s"}`, recipient.Number, pin)
+		req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		return req
+	}
+
+	// Missing and wrong PINs are rejected without moving money
+	rec = serve(server, transferRequest(token, recipient.Number, 1000))
+	assert.Contains(t, rec.Body.String(), "invalid_pin")
+	rec = serve(server, withPin("0000"))
+	assert.Contains(t, rec.Body.String(), "invalid_pin")
+	sender, _ = store.GetAccountByID(context.Background(), sender.ID)
+	assert.Equal(t, Money(9900), sender.Balance)
+
+	// Too many wrong PINs lock high-value transfers, even with the correct PIN
+	rec = serve(server, withPin("4321"))
+	assert.Contains(t, rec.Body.String(), "pin_locked")
+
+	// Once the lockout is over the correct PIN goes through
+	server.pinLockout.now = func() time.Time { return time.Now().Add(config.PinLockout) }
+	rec = serve(server, withPin("4321"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestTransferReceipt tests that a transfer responds with a receipt carrying the reference and post-balance
+func TestTransferReceipt(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 250))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	receipt := new(TransferReceipt)
+	decodeData(t, rec.Body, receipt)
+	assert.Equal(t, store.transactions[0].Reference, receipt.Reference)
+	assert.Regexp(t, `^TXN-\d{4}-0001-[A-Z2-9]{4}$`, receipt.Reference)
+	assert.Equal(t, Money(250), receipt.Amount)
+	assert.Equal(t, Money(0), receipt.Fee)
+	assert.Equal(t, Money(750), receipt.SenderBalance)
+	assert.Equal(t, maskNumber(sender.Number), receipt.FromAccount)
+	assert.Equal(t, maskNumber(recipient.Number), receipt.ToAccount)
+	assert.NotContains(t, rec.Body.String(), fmt.Sprint(recipient.Number))
+}
+
+// TestTransferDestinationDailyCap tests that the total sent to one recipient per day is capped
+func TestTransferDestinationDailyCap(t *testing.T) {
+	config := newTestConfig(t)
+	config.DestinationDailyCap = 500
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	first, _ := newTestAccount(t, store, RoleUser)
+	second, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 2000))
+
+	rec := serve(server, transferRequest(token, first.Number, 300))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A second transfer to the same recipient crosses the cap
+	rec = serve(server, transferRequest(token, first.Number, 201))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "destination_limit_exceeded")
+	assert.Contains(t, rec.Body.String(), "200 left today")
+
+	// Other recipients and yesterday's transfers don't count toward it
+	store.transactions[0].CreatedAt = store.transactions[0].CreatedAt.AddDate(0, 0, -1)
+	rec = serve(server, transferRequest(token, second.Number, 500))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(server, transferRequest(token, first.Number, 500))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestTransferDailyLimit tests that the total an account sends per UTC day is capped across all recipients
+func TestTransferDailyLimit(t *testing.T) {
+	config := newTestConfig(t)
+	config.DailyTransferLimit = 500
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	first, _ := newTestAccount(t, store, RoleUser)
+	second, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 2000))
+
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, first.Number, 300)).Code)
+
+	// Another recipient still counts toward the limit
+	rec := serve(server, transferRequest(token, second.Number, 201))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "daily_limit_exceeded")
+	assert.Contains(t, rec.Body.String(), "200 left today")
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, second.Number, 200)).Code)
+
+	// The limit resets at midnight UTC
+	for _, transaction := range store.transactions {
+		transaction.CreatedAt = transaction.CreatedAt.AddDate(0, 0, -1)
+	}
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, first.Number, 500)).Code)
+}
+
+// TestTransferAmountLimits tests that amounts outside the configured per-transfer limits are rejected
+func TestTransferAmountLimits(t *testing.T) {
+	config := newTestConfig(t)
+	config.TransferMinAmount = 100
+	config.TransferMaxAmount = 500
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 2000))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 99))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "amount_below_minimum")
+	assert.Contains(t, rec.Body.String(), "at least 1.00")
+
+	rec = serve(server, transferRequest(token, recipient.Number, 501))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "amount_above_maximum")
+	assert.Contains(t, rec.Body.String(), "at most 5.00")
+
+	// The limits themselves are allowed
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 100)).Code)
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 500)).Code)
+	assert.Len(t, store.transactions, 2)
+}
+
+// TestTransferPrivateNoteHiddenFromRecipient tests that the recipient sees the shared memo but not the sender's private note
+func TestTransferPrivateNoteHiddenFromRecipient(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	sender, senderToken := newTestAccount(t, store, RoleUser)
+	recipient, recipientToken := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	body := fmt.Sprintf(`{"toAccount":%d,"amount":100,"memo":"rent","note":"paid late again"}`, recipient.Number)
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+	req.Header.Set("x-jwt-token", senderToken)
+	assert.Equal(t, http.StatusOK, serve(server, req).Code)
+
+	history := func(acc *Account, token string) []*Transaction {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/account/%d/transactions", acc.ID), nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := serve(server, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		transactions := []*Transaction{}
+		decodeData(t, rec.Body, &transactions)
+		assert.Len(t, transactions, 1)
+		return transactions
+	}
+
+	// The sender sees both
+	sent := history(sender, senderToken)[0]
+	assert.Equal(t, "rent", sent.Memo)
+	assert.Equal(t, "paid late again", sent.PrivateNote)
+
+	// The recipient only sees the shared memo
+	received := history(recipient, recipientToken)[0]
+	assert.Equal(t, "rent", received.Memo)
+	assert.Empty(t, received.PrivateNote)
+}
+
+// TestTransferCooldown tests that a second transfer within the cooldown is rejected with 429
+func TestTransferCooldown(t *testing.T) {
+	config := newTestConfig(t)
+	config.TransferCooldown = 2 * time.Second
+	server, store := newTestServer(config)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Half a second later is too soon
+	now = now.Add(500 * time.Millisecond)
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "transfer_cooldown")
+
+	// Once the cooldown passed the transfer goes through
+	now = now.Add(1500 * time.Millisecond)
+	rec = serve(server, transferRequest(token, recipient.Number, 100))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestReciprocalTransfersDontDeadlock tests that concurrent transfers between two accounts in opposite
+// directions all complete and conserve the total balance
+func TestReciprocalTransfersDontDeadlock(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	first, firstToken := newTestAccount(t, store, RoleUser)
+	second, secondToken := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), first.ID, 1000))
+	assert.Nil(t, store.UpdateBalance(context.Background(), second.ID, 1000))
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			serve(server, transferRequest(firstToken, second.Number, 1))
+		}()
+		go func() {
+			defer wg.Done()
+			serve(server, transferRequest(secondToken, first.Number, 1))
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reciprocal transfers deadlocked")
+	}
+
+	accounts, err := store.GetAccountsByIDs(context.Background(), []int{first.ID, second.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, Money(2000), accounts[0].Balance+accounts[1].Balance)
+	assert.Len(t, store.transactions, 2*rounds)
+}
+
+// TestAnomalousTransferFlagged tests that a transfer far above the sender's average is flagged while a usual one isn't
+func TestAnomalousTransferFlagged(t *testing.T) {
+	config := newTestConfig(t)
+	config.AnomalyFactor = 10
+	config.AnomalyMinHistory = 5
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 100000))
+
+	// Build up a history averaging 100
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 100)).Code)
+	}
+	for _, tr := range store.transactions {
+		assert.False(t, tr.Flagged)
+	}
+
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 150)).Code)
+	assert.False(t, store.transactions[len(store.transactions)-1].Flagged)
+
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 5000)).Code)
+	assert.True(t, store.transactions[len(store.transactions)-1].Flagged)
+}
+
+// TestAnomalousTransferHeld tests that flagged transfers wait for approval when holding is enabled
+func TestAnomalousTransferHeld(t *testing.T) {
+	config := newTestConfig(t)
+	config.AnomalyFactor = 10
+	config.AnomalyMinHistory = 1
+	config.AnomalyHold = true
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 100000))
+
+	assert.Equal(t, http.StatusOK, serve(server, transferRequest(token, recipient.Number, 100)).Code)
+
+	rec := serve(server, transferRequest(token, recipient.Number, 5000))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Contains(t, rec.Body.String(), TransferPendingApproval)
+	assert.Len(t, store.transactions, 1)
+
+	acc, _ := store.GetAccountByID(context.Background(), sender.ID)
+	assert.Equal(t, Money(99900), acc.Balance)
+}
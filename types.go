@@ -1,11 +1,35 @@
 package main
 
 import (
-	"math/rand"        // Import the rand package for generating random numbers
-	"time"             // Import the time package for time-related operations
+	"fmt"                        // Import the fmt package for formatting errors
 	"golang.org/x/crypto/bcrypt" // Import bcrypt for password hashing and comparison
+	"regexp"                     // Import regexp to check the shape of email addresses
+	"time"                       // Import the time package for time-related operations
+	"unicode/utf8"               // Import utf8 to count the characters of passwords
 )
 
+// AccountPage is a page of the account list together with the information needed to request the others
+type AccountPage struct {
+	Accounts   []*Account `json:"accounts"`             // Accounts of the page, ordered by ID
+	Total      int        `json:"total"`                // Number of accounts across all pages
+	Limit      int        `json:"limit"`                // Maximum number of accounts per page
+	Offset     int        `json:"offset"`               // Number of accounts before this page
+	NextCursor string     `json:"nextCursor,omitempty"` // Cursor of the next page when listing by cursor, empty on the last page
+}
+
+// TransactionPage is a page of the transaction history listed by cursor
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`         // Transactions of the page, newest first
+	NextCursor   string         `json:"nextCursor,omitempty"` // Cursor of the next page, empty on the last page
+}
+
+// AccountFilter narrows down the accounts listed and counted
+type AccountFilter struct {
+	IncludeDeleted bool      // Also list the soft deleted accounts
+	CreatedAfter   time.Time // Earliest creation time listed, unbounded when zero
+	CreatedBefore  time.Time // Latest creation time listed, unbounded when zero
+}
+
 // LoginResponse represents the response structure for login requests
 type LoginResponse struct {
 	Number int64  `json:"number"` // Account number
@@ -14,14 +38,203 @@ type LoginResponse struct {
 
 // LoginRequest represents the structure of a login request
 type LoginRequest struct {
-	Number   int64  `json:"number"`   // Account number
-	Password string `json:"password"` // Password for authentication
+	Number   int64  `json:"number,omitempty"` // Account number, unless logging in by email
+	Email    string `json:"email,omitempty"`  // Email address, unless logging in by account number
+	Password string `json:"password"`         // Password for authentication
 }
 
 // TransferRequest represents the structure of a transfer request
 type TransferRequest struct {
-	ToAccount int `json:"toAccount"` // Account number to which the amount is transferred
-	Amount    int `json:"amount"`    // Amount to be transferred
+	ToAccount     int    `json:"toAccount"`               // Account number to which the amount is transferred
+	Amount        Money  `json:"amount"`                  // Amount to be transferred, in minor units
+	AmountDecimal string `json:"amountDecimal,omitempty"` // Amount in major units, e.g. "12.34", instead of amount
+	Pin           string `json:"pin,omitempty"`           // Transaction PIN, required for high-value transfers
+	Memo          string `json:"memo,omitempty"`          // Memo shown to both the sender and the recipient
+	Note          string `json:"note,omitempty"`          // Private note only shown to the sender
+}
+
+// TransferReceipt represents the response to a completed transfer
+type TransferReceipt struct {
+	Reference     string          `json:"reference"`     // Human-shareable transaction reference
+	CreatedAt     time.Time       `json:"createdAt"`     // Time the transfer was executed
+	Amount        Money           `json:"amount"`        // Amount transferred
+	AmountDisplay string          `json:"amountDisplay"` // Amount formatted for display
+	Currency      string          `json:"currency"`      // ISO 4217 currency of the amounts
+	Fee           Money           `json:"fee"`           // Fee charged to the sender
+	FromAccount   string          `json:"fromAccount"`   // Masked number of the sender account
+	ToAccount     string          `json:"toAccount"`     // Masked number of the recipient account
+	SenderBalance Money           `json:"senderBalance"` // Sender balance after the transfer
+	changes       []balanceChange // Balance changes of both parties, notified once the transfer commits
+}
+
+// Transaction kinds
+const (
+	TransactionTransfer   = "transfer"   // Money moved between two accounts
+	TransactionDeposit    = "deposit"    // Money added to an account from outside the bank
+	TransactionWithdrawal = "withdrawal" // Money taken out of the bank from an account
+	TransactionInterest   = "interest"   // Interest the bank credits to an account
+)
+
+// Transaction records a movement of money between accounts
+type Transaction struct {
+	ID            int       `json:"id"`                      // Unique identifier of the transaction
+	Reference     string    `json:"reference"`               // Human-shareable reference, e.g. TXN-2024-0001-ABCD
+	FromAccount   int       `json:"fromAccount"`             // ID of the debited account, 0 when money enters the bank
+	ToAccount     int       `json:"toAccount"`               // ID of the credited account, 0 when money leaves the bank
+	Amount        Money     `json:"amount"`                  // Amount moved
+	Kind          string    `json:"kind"`                    // Kind of transaction, e.g. transfer
+	CreatedAt     time.Time `json:"createdAt"`               // Transaction timestamp
+	Memo          string    `json:"memo,omitempty"`          // Memo shared by both parties
+	PrivateNote   string    `json:"privateNote,omitempty"`   // Note of the sender, hidden from everyone else
+	Hash          string    `json:"hash,omitempty"`          // Hash of the contents and the previous hashes, empty when chaining is disabled
+	FromPrevHash  string    `json:"-"`                       // Hash of the previous transaction of the debited account
+	ToPrevHash    string    `json:"-"`                       // Hash of the previous transaction of the credited account
+	Flagged       bool      `json:"flagged,omitempty"`       // Amount far above the sender's usual transfers
+	Rate          float64   `json:"rate,omitempty"`          // Yearly rate interest was accrued at, for interest transactions
+	PeriodSeconds int64     `json:"periodSeconds,omitempty"` // Length of the period interest was accrued for, for interest transactions
+	AmountDisplay string    `json:"amountDisplay,omitempty"` // Amount formatted for display, filled in by the API
+}
+
+// Pending transfer states
+const (
+	TransferPendingApproval = "pending_approval" // Waiting for a second approver
+	TransferApproved        = "approved"         // Approved and executed
+	TransferCancelled       = "cancelled"        // Expired before it was approved
+)
+
+// PendingTransfer is a transfer held until a second user approves it
+type PendingTransfer struct {
+	ID          int       `json:"id"`                    // Unique identifier of the pending transfer
+	FromAccount int       `json:"fromAccount"`           // ID of the account to debit
+	ToAccount   int       `json:"toAccount"`             // Account number to credit
+	Amount      Money     `json:"amount"`                // Amount to transfer
+	Memo        string    `json:"memo,omitempty"`        // Memo shared by both parties
+	PrivateNote string    `json:"privateNote,omitempty"` // Note of the sender
+	Status      string    `json:"status"`                // One of the pending transfer states
+	CreatedAt   time.Time `json:"createdAt"`             // Time the transfer was requested
+	ExpiresAt   time.Time `json:"expiresAt"`             // Time the transfer is cancelled unless approved
+	ApprovedBy  int       `json:"approvedBy,omitempty"`  // ID of the approving account
+}
+
+// DepositRequest represents the structure of a deposit request
+type DepositRequest struct {
+	Amount Money `json:"amount"` // Amount to add to the balance
+}
+
+// WithdrawalRequest represents the structure of a withdrawal request
+type WithdrawalRequest struct {
+	Amount Money `json:"amount"` // Amount to take from the balance
+}
+
+// Audit log actions
+const (
+	AuditAccountExport   = "account_export"   // An account's data was exported
+	AuditNumberRotation  = "number_rotation"  // An account was given a new account number
+	AuditAccountFreeze   = "account_freeze"   // An account was frozen
+	AuditAccountUnfreeze = "account_unfreeze" // A frozen account was returned to normal use
+)
+
+// AuditEntry records who performed a sensitive action on which account
+type AuditEntry struct {
+	ID        int       `json:"id"`        // Unique identifier of the entry
+	ActorID   int       `json:"actorId"`   // ID of the account that performed the action
+	Action    string    `json:"action"`    // One of the audit log actions
+	AccountID int       `json:"accountId"` // ID of the account the action was performed on
+	CreatedAt time.Time `json:"createdAt"` // Time of the action
+}
+
+// AccountExport is the data export of an account and its transactions
+type AccountExport struct {
+	Account      *Account       `json:"account"`      // The exported account
+	Transactions []*Transaction `json:"transactions"` // Every transaction of the account, newest first
+	ExportedAt   time.Time      `json:"exportedAt"`   // Time of the export
+}
+
+// Statement lists the transactions of an account over a period together with the balances they led to
+type Statement struct {
+	Number         int64           `json:"number"`         // Account number
+	Holder         string          `json:"holder"`         // Name of the account holder
+	Currency       string          `json:"currency"`       // ISO 4217 currency of the amounts
+	From           time.Time       `json:"from"`           // Start of the period
+	To             time.Time       `json:"to"`             // End of the period, excluded
+	OpeningBalance Money           `json:"openingBalance"` // Balance at the start of the period
+	ClosingBalance Money           `json:"closingBalance"` // Balance at the end of the period
+	Lines          []StatementLine `json:"lines"`          // Transactions of the period, oldest first
+}
+
+// StatementLine is a single transaction on a statement
+type StatementLine struct {
+	Date          time.Time `json:"date"`                   // Time of the transaction
+	Reference     string    `json:"reference"`              // Human-shareable reference of the transaction
+	Kind          string    `json:"kind"`                   // Kind of transaction, e.g. transfer
+	Counterparty  string    `json:"counterparty,omitempty"` // Masked number of the other account, empty when there is none
+	Amount        Money     `json:"amount"`                 // Amount credited, negative when debited
+	AmountDisplay string    `json:"amountDisplay"`          // Amount formatted for display
+	Balance       Money     `json:"balance"`                // Balance after the transaction
+}
+
+// AccountNumberChange records an account number retired by a rotation, which is never assigned again
+type AccountNumberChange struct {
+	ID        int       `json:"id"`        // Unique identifier of the change
+	AccountID int       `json:"accountId"` // ID of the account that was renumbered
+	OldNumber int64     `json:"oldNumber"` // Retired account number
+	NewNumber int64     `json:"newNumber"` // Account number assigned instead
+	ActorID   int       `json:"actorId"`   // ID of the admin that rotated the number
+	RotatedAt time.Time `json:"rotatedAt"` // Time of the rotation
+}
+
+// SetPinRequest represents the structure of a transaction PIN enrollment request
+type SetPinRequest struct {
+	Password string `json:"password"` // Current account password
+	Pin      string `json:"pin"`      // New transaction PIN of 4 to 6 digits
+}
+
+// ChangePasswordRequest represents the structure of a request changing the account password
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"` // Current account password
+	NewPassword string `json:"newPassword"` // Password replacing it
+}
+
+// UpdateAccountRequest represents the structure of a request changing the name of the account holder
+// Omitted fields are left unchanged
+type UpdateAccountRequest struct {
+	FirstName *string `json:"firstName"` // New first name of the account holder
+	LastName  *string `json:"lastName"`  // New last name of the account holder
+}
+
+// AcceptsInboundRequest represents the structure of a request toggling incoming transfers
+type AcceptsInboundRequest struct {
+	AcceptsInbound bool `json:"acceptsInbound"` // Whether the account accepts incoming transfers
+}
+
+// WebhookRequest represents the structure of a request setting the webhook of an account
+type WebhookRequest struct {
+	URL string `json:"url"` // HTTP(S) URL notified of balance changes, empty to remove the webhook
+}
+
+// WebhookEvent is the body POSTed to the webhook of an account whose balance changed
+type WebhookEvent struct {
+	Type          string `json:"type"`          // Kind of transaction that changed the balance, e.g. transfer
+	AccountNumber int64  `json:"accountNumber"` // Number of the account
+	Amount        Money  `json:"amount"`        // Amount credited, negative when debited
+	NewBalance    Money  `json:"newBalance"`    // Balance after the change
+}
+
+// PasswordResetRequest represents the structure of a request for a password reset email
+type PasswordResetRequest struct {
+	Email string `json:"email"` // Email address of the account
+}
+
+// PasswordResetConfirmRequest represents the structure of a request setting a new password with a reset token
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`    // Token from the password reset email
+	Password string `json:"password"` // New password for the account
+}
+
+// HealthResponse represents the structure of the health check response
+type HealthResponse struct {
+	Status string `json:"status"` // Health state: ok, degraded or unavailable
+	Writes string `json:"writes"` // Whether writes are available or unavailable
 }
 
 // CreateAccountRequest represents the structure of a create account request
@@ -29,17 +242,111 @@ type CreateAccountRequest struct {
 	FirstName string `json:"firstName"` // First name of the account holder
 	LastName  string `json:"lastName"`  // Last name of the account holder
 	Password  string `json:"password"`  // Password for the new account
+	Type      string `json:"type"`      // Account type, the configured default when empty
+	Currency  string `json:"currency"`  // ISO 4217 currency of the account, the configured default when empty
+	Email     string `json:"email"`     // Email address, required when accounts must be activated
+}
+
+// AccountType describes the rules applied to every account of a given type
+type AccountType struct {
+	Name         string  // Type name, e.g. checking or savings
+	MinBalance   Money   // Balance the account may not drop below
+	InterestRate float64 // Interest rate credited to the balance
 }
 
+// Purposes of the tokens emailed to account holders
+const (
+	TokenActivation    = "activation"     // Confirms the email address of a new account
+	TokenPasswordReset = "password_reset" // Sets a new password without knowing the current one
+)
+
+// AccountToken is a single-use token emailed to an account holder; only its hash is stored
+type AccountToken struct {
+	ID        int        // Unique identifier for the token
+	AccountID int        // Account the token was issued for
+	Purpose   string     // What the token may be used for, e.g. activation
+	TokenHash string     // SHA-256 hash of the token
+	ExpiresAt time.Time  // Time after which the token is rejected
+	UsedAt    *time.Time // Time the token was used, nil while unused
+}
+
+// Account roles
+const (
+	RoleUser  = "user"  // Regular account holder
+	RoleAdmin = "admin" // Operator with access to every account
+)
+
+// Account lifecycle states
+const (
+	StatusActive = "active" // Account in normal use
+	StatusFrozen = "frozen" // Account temporarily blocked from moving money out
+	StatusClosed = "closed" // Account permanently out of use
+)
+
 // Account represents an individual account's details
 type Account struct {
-	ID                int       `json:"id"`                // Unique identifier for the account
-	FirstName         string    `json:"firstName"`         // First name of the account holder
-	LastName          string    `json:"lastName"`          // Last name of the account holder
-	Number            int64     `json:"number"`            // Account number
-	EncryptedPassword string    `json:"-"`                 // Encrypted password (not included in JSON serialization)
-	Balance           int64     `json:"balance"`           // Account balance
-	CreatedAt         time.Time `json:"createdAt"`         // Account creation timestamp
+	ID                int        `json:"id"`                   // Unique identifier for the account
+	FirstName         string     `json:"firstName"`            // First name of the account holder
+	LastName          string     `json:"lastName"`             // Last name of the account holder
+	Number            int64      `json:"number"`               // Account number
+	EncryptedPassword string     `json:"-"`                    // Encrypted password (not included in JSON serialization)
+	Balance           Money      `json:"balance"`              // Account balance
+	Currency          string     `json:"currency"`             // ISO 4217 currency of the balance, e.g. USD
+	CreatedAt         time.Time  `json:"createdAt"`            // Account creation timestamp
+	Role              string     `json:"role"`                 // Account role (user or admin)
+	Type              string     `json:"type"`                 // Account type, e.g. checking or savings
+	MinBalance        Money      `json:"minBalance"`           // Balance the account may not drop below
+	InterestRate      float64    `json:"interestRate"`         // Interest rate credited to the balance
+	AcceptsInbound    bool       `json:"acceptsInbound"`       // Whether the account accepts incoming transfers
+	EncryptedPin      string     `json:"-"`                    // Hashed transaction PIN, empty when not enrolled
+	Email             string     `json:"email"`                // Email address of the account holder
+	Activated         bool       `json:"activated"`            // Whether the account holder confirmed their email address
+	Status            string     `json:"status"`               // Lifecycle state, one of the account states
+	WebhookURL        string     `json:"webhookUrl,omitempty"` // URL notified of balance changes, empty for none
+	DeletedAt         *time.Time `json:"deletedAt,omitempty"`  // When the account was deleted, nil while it is in use
+	BalanceDisplay    string     `json:"balanceDisplay"`       // Balance formatted for display, filled in by the API
+	CheckDigit        *int64     `json:"checkDigit,omitempty"` // Luhn check digit of the number, filled in by the API when enabled and the number has one
+}
+
+// AccountSummary is the minimal projection of an account shown to its owner in listings,
+// leaving out the internal status and rule fields only admins need
+type AccountSummary struct {
+	ID             int       `json:"id"`             // Unique identifier for the account
+	FirstName      string    `json:"firstName"`      // First name of the account holder
+	LastName       string    `json:"lastName"`       // Last name of the account holder
+	Number         int64     `json:"number"`         // Account number
+	Type           string    `json:"type"`           // Account type, e.g. checking or savings
+	Balance        Money     `json:"balance"`        // Account balance
+	Currency       string    `json:"currency"`       // ISO 4217 currency of the balance
+	BalanceDisplay string    `json:"balanceDisplay"` // Balance formatted for display
+	CreatedAt      time.Time `json:"createdAt"`      // Account creation timestamp
+}
+
+// BalanceResponse is the balance of an account alone, for clients polling it
+type BalanceResponse struct {
+	Number   int64  `json:"number"`   // Account number
+	Balance  Money  `json:"balance"`  // Account balance
+	Currency string `json:"currency"` // ISO 4217 currency of the balance
+}
+
+// Summary returns the owner projection of the account
+func (a *Account) Summary() *AccountSummary {
+	return &AccountSummary{
+		ID:             a.ID,
+		FirstName:      a.FirstName,
+		LastName:       a.LastName,
+		Number:         a.Number,
+		Type:           a.Type,
+		Balance:        a.Balance,
+		Currency:       a.Currency,
+		BalanceDisplay: a.BalanceDisplay,
+		CreatedAt:      a.CreatedAt,
+	}
+}
+
+// IsAdmin reports whether the account has the admin role
+func (a *Account) IsAdmin() bool {
+	return a.Role == RoleAdmin
 }
 
 // ValidPassword checks if the provided password matches the stored encrypted password
@@ -47,8 +354,72 @@ func (a *Account) ValidPassword(pw string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(a.EncryptedPassword), []byte(pw)) == nil
 }
 
-// NewAccount creates a new account with a hashed password and random account number
-func NewAccount(firstName, lastName, password string) (*Account, error) {
+// ValidPin checks if the provided transaction PIN matches the stored hashed PIN
+func (a *Account) ValidPin(pin string) bool {
+	if a.EncryptedPin == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(a.EncryptedPin), []byte(pin)) == nil
+}
+
+// maskNumber hides all but the last 4 digits of an account number, e.g. 12345678 becomes ****5678
+func maskNumber(number int64) string {
+	digits := fmt.Sprintf("%d", number)
+	if len(digits) <= 4 {
+		return digits
+	}
+	return "****" + digits[len(digits)-4:]
+}
+
+// minPasswordLength is the minimum number of characters of an account password
+const minPasswordLength = 8
+
+// validatePassword checks that an account password is present and long enough
+func validatePassword(pw string) error {
+	if pw == "" {
+		return validationErrorf("password is required")
+	}
+	if utf8.RuneCountInString(pw) < minPasswordLength {
+		return validationErrorf("password must have at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+// maxEmailLength is the longest email address the account table stores
+const maxEmailLength = 254
+
+// emailPattern is a loose check of an email address, a local part and a dotted domain; only delivery proves it
+var emailPattern = regexp.MustCompile(`^[^\s@]+@[^\s@.]+(\.[^\s@.]+)+$`)
+
+// validateEmail checks that an email address looks deliverable
+func validateEmail(email string) error {
+	if len(email) > maxEmailLength || !emailPattern.MatchString(email) {
+		return validationErrorf("email address is invalid")
+	}
+	return nil
+}
+
+// validatePin checks that a transaction PIN consists of 4 to 6 digits
+func validatePin(pin string) error {
+	if len(pin) < 4 || len(pin) > 6 {
+		return validationErrorf("PIN must have 4 to 6 digits")
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return validationErrorf("PIN must have 4 to 6 digits")
+		}
+	}
+	return nil
+}
+
+// NewAccount creates a new account of the given type with a hashed password
+// The account number is assigned by the caller according to the configured AccountNumberPolicy
+func NewAccount(firstName, lastName, password string, accType AccountType) (*Account, error) {
+	// Reject weak passwords before spending time on hashing them
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
 	// Hash the password using bcrypt
 	encpw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -60,7 +431,12 @@ func NewAccount(firstName, lastName, password string) (*Account, error) {
 		FirstName:         firstName,
 		LastName:          lastName,
 		EncryptedPassword: string(encpw),
-		Number:            int64(rand.Intn(1000000)), // Generate a random account number
-		CreatedAt:         time.Now().UTC(),          // Set the account creation time to the current UTC time
+		CreatedAt:         time.Now().UTC(), // Set the account creation time to the current UTC time
+		Role:              RoleUser,
+		Type:              accType.Name, // Apply the defaults of the account type
+		MinBalance:        accType.MinBalance,
+		InterestRate:      accType.InterestRate,
+		AcceptsInbound:    true,
+		Status:            StatusActive,
 	}, nil
 }
@@ -10,11 +10,23 @@ import (
 // TestNewAccount tests the NewAccount function for creating a new account
 func TestNewAccount(t *testing.T) {
 	// Create a new account with given first name, last name, and password
-	acc, err := NewAccount("a", "b", "hunter")
+	acc, err := NewAccount("a", "b", "hunter88", AccountType{Name: "checking"})
 
 	// Assert that there is no error during account creation
 	assert.Nil(t, err)
 
 	// Print the created account details for debugging purposes
 	fmt.Printf("%+v\n", acc)
-}
\ No newline at end of file
+}
+
+// TestValidatePassword tests that empty and short passwords are rejected
+func TestValidatePassword(t *testing.T) {
+	assert.EqualError(t, validatePassword(""), "password is required")
+	assert.EqualError(t, validatePassword("a"), "password must have at least 8 characters")
+	assert.EqualError(t, validatePassword("hunter8"), "password must have at least 8 characters")
+	assert.Nil(t, validatePassword("hunter88"))
+
+	// Weak passwords never make it to an account
+	_, err := NewAccount("a", "b", "short", AccountType{Name: "checking"})
+	assert.EqualError(t, err, "password must have at least 8 characters")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxWebhookURLLength is the longest webhook URL the account table stores
+const maxWebhookURLLength = 2048
+
+// webhookTimeout bounds a single webhook delivery, so a slow receiver can't pile up goroutines
+const webhookTimeout = 10 * time.Second
+
+// errWebhookAddressBlocked reports a webhook delivery to an address account holders may not reach through the server
+var errWebhookAddressBlocked = errors.New("webhook address is loopback, private or link-local")
+
+// balanceChange is a change of an account balance to notify the account's webhook of
+type balanceChange struct {
+	Account *Account // Account after the change
+	Kind    string   // Kind of transaction that changed the balance
+	Amount  Money    // Amount credited, negative when debited
+}
+
+// webhookDispatcher delivers balance change events to the webhooks of the accounts in the background,
+// retrying failed deliveries with exponential backoff
+type webhookDispatcher struct {
+	client   *http.Client
+	attempts int                 // Deliveries tried before an event is dropped
+	backoff  time.Duration       // Wait before the first retry, doubling with every further one
+	sleep    func(time.Duration) // Waits between retries, replaced in tests
+}
+
+// newWebhookDispatcher creates a dispatcher trying each event the given number of times
+// Unless allowPrivate is set, deliveries to internal addresses are refused when connecting, which also covers
+// host names resolving to them and redirects
+func newWebhookDispatcher(attempts int, backoff time.Duration, allowPrivate bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+				return errWebhookAddressBlocked
+			}
+			return nil
+		}
+	}
+
+	// No proxy, it would connect to the receiver in place of the checked dialer
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout}
+	return &webhookDispatcher{
+		client:   &http.Client{Timeout: webhookTimeout, Transport: transport},
+		attempts: attempts,
+		backoff:  backoff,
+		sleep:    time.Sleep,
+	}
+}
+
+// Notify sends the changes to the webhooks of their accounts without waiting for the deliveries
+// Only call it once the changes are committed, so receivers never hear of rolled back ones
+func (d *webhookDispatcher) Notify(changes ...balanceChange) {
+	for _, change := range changes {
+		if change.Account.WebhookURL == "" {
+			continue
+		}
+		event := WebhookEvent{
+			Type:          change.Kind,
+			AccountNumber: change.Account.Number,
+			Amount:        change.Amount,
+			NewBalance:    change.Account.Balance,
+		}
+		go d.deliver(change.Account.WebhookURL, event)
+	}
+}
+
+// deliver POSTs the event to the webhook until it is accepted or the attempts run out
+func (d *webhookDispatcher) deliver(webhookURL string, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("encoding webhook event failed: %v\n", err)
+		return
+	}
+
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		err = d.post(webhookURL, body)
+		if err == nil {
+			return
+		}
+		if attempt >= d.attempts {
+			break
+		}
+		d.sleep(wait)
+		wait *= 2
+	}
+	log.Printf("webhook delivery of %s event for account %s failed after %d attempts: %v\n",
+		event.Type, maskNumber(event.AccountNumber), d.attempts, err)
+}
+
+// post makes a single delivery, failing unless the receiver answers with a 2xx status
+func (d *webhookDispatcher) post(webhookURL string, body []byte) error {
+	resp, err := d.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// blockedWebhookIP reports whether the address belongs to the server itself or an internal network,
+// e.g. 127.0.0.1, 10.0.0.1 or the cloud metadata service at 169.254.169.254
+func blockedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// validateWebhookURL checks that a webhook URL is an absolute HTTP(S) URL and, unless allowPrivate is set,
+// that it doesn't name an internal host; host names are resolved again when delivering
+func validateWebhookURL(webhookURL string, allowPrivate bool) error {
+	if len(webhookURL) > maxWebhookURLLength {
+		return validationErrorf("webhook url must not be longer than %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return validationErrorf("webhook url must be an absolute http or https URL")
+	}
+	if allowPrivate {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && blockedWebhookIP(ip)) {
+		return validationErrorf("webhook url must not point to a loopback, private or link-local address")
+	}
+	return nil
+}
+
+// handleSetWebhook sets or, with an empty URL, removes the webhook notified of balance changes of the account
+func (s *APIServer) handleSetWebhook(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	// Decode the request body
+	req := new(WebhookRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if req.URL != "" {
+		if err := validateWebhookURL(req.URL, s.config.WebhookAllowPrivate); err != nil {
+			return err
+		}
+	}
+
+	// Store the new webhook and send the updated account as response
+	if err := s.store.SetWebhookURL(r.Context(), id, req.URL); err != nil {
+		return err
+	}
+	account, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	s.presentAccounts(account)
+	return WriteJSON(w, http.StatusOK, account)
+}
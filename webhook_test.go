@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver starts a server passing the webhook events it receives to the returned channel
+func webhookReceiver(t *testing.T) (*httptest.Server, chan WebhookEvent) {
+	events := make(chan WebhookEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := WebhookEvent{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, events
+}
+
+// nextWebhookEvent waits for the next event the receiver gets
+func nextWebhookEvent(t *testing.T, events chan WebhookEvent) WebhookEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook event received")
+		return WebhookEvent{}
+	}
+}
+
+// TestWebhookNotifiesBalanceChanges tests that both parties of a transfer are notified of their new balances
+func TestWebhookNotifiesBalanceChanges(t *testing.T) {
+	// The receiver listens on loopback
+	config := newTestConfig(t)
+	config.WebhookAllowPrivate = true
+	server, store := newTestServer(config)
+	sender, token := newTestAccount(t, store, RoleUser)
+	recipient, _ := newTestAccount(t, store, RoleUser)
+	assert.Nil(t, store.UpdateBalance(context.Background(), sender.ID, 1000))
+	receiver, events := webhookReceiver(t)
+
+	// Only absolute HTTP(S) URLs are accepted
+	setWebhook := func(url string) int {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/account/%d/webhook", sender.ID), strings.NewReader(`{"url":"`+url+`"}`))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req).Code
+	}
+	for _, url := range []string{"example.com/hook", "ftp://example.com/hook", "/hook"} {
+		assert.Equal(t, http.StatusBadRequest, setWebhook(url), url)
+	}
+	assert.Equal(t, http.StatusOK, setWebhook(receiver.URL))
+	assert.Nil(t, store.SetWebhookURL(context.Background(), recipient.ID, receiver.URL))
+
+	rec := serve(server, transferRequest(token, recipient.Number, 300))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Deliveries run concurrently, so match the events by account
+	got := map[int64]WebhookEvent{}
+	for i := 0; i < 2; i++ {
+		event := nextWebhookEvent(t, events)
+		got[event.AccountNumber] = event
+	}
+	assert.Equal(t, WebhookEvent{Type: TransactionTransfer, AccountNumber: sender.Number, Amount: -300, NewBalance: 700}, got[sender.Number])
+	assert.Equal(t, WebhookEvent{Type: TransactionTransfer, AccountNumber: recipient.Number, Amount: 300, NewBalance: 300}, got[recipient.Number])
+}
+
+// TestWebhookRetries tests that failed deliveries are retried with a growing backoff until the attempts run out
+func TestWebhookRetries(t *testing.T) {
+	var calls, failures int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	dispatcher := newWebhookDispatcher(3, time.Second, true)
+	var waits []time.Duration
+	dispatcher.sleep = func(d time.Duration) { waits = append(waits, d) }
+	event := WebhookEvent{Type: TransactionDeposit, AccountNumber: 100001, Amount: 100, NewBalance: 100}
+
+	// Accepted on the last attempt
+	atomic.StoreInt32(&failures, 2)
+	dispatcher.deliver(receiver.URL, event)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+
+	// Dropped once the attempts run out
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 5)
+	dispatcher.deliver(receiver.URL, event)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+// TestWebhookBlocksInternalAddresses tests that webhooks can't make the server call its own or internal networks
+func TestWebhookBlocksInternalAddresses(t *testing.T) {
+	server, store := newTestServer(newTestConfig(t))
+	acc, token := newTestAccount(t, store, RoleUser)
+
+	setWebhook := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/account/%d/webhook", acc.ID), strings.NewReader(`{"url":"`+url+`"}`))
+		req.Header.Set("x-jwt-token", token)
+		return serve(server, req)
+	}
+	for _, url := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		rec := setWebhook(url)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, url)
+		assert.Contains(t, rec.Body.String(), "loopback, private or link-local", url)
+	}
+	assert.Equal(t, http.StatusOK, setWebhook("https://hooks.example.com/bank").Code)
+
+	// Deliveries are checked again when connecting, where host names are resolved
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer receiver.Close()
+
+	dispatcher := newWebhookDispatcher(1, time.Second, false)
+	err := dispatcher.post(receiver.URL, []byte("{}"))
+	assert.ErrorIs(t, err, errWebhookAddressBlocked)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}